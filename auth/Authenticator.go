@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"bootcamp_task/config"
+	"bootcamp_task/storage/errs"
+	"bootcamp_task/storage/repositories"
+	"context"
+	"errors"
+)
+
+const (
+	SESSION = "session"
+	JWT     = "jwt"
+)
+
+var (
+	ErrInvalidToken = errs.Unauthorized("unauthorized", "unauthorized")
+	ErrForbidden    = errs.Forbidden("forbidden", "forbidden")
+)
+
+type Role string
+
+const (
+	CLIENT    Role = "client"
+	MODERATOR Role = "moderator"
+)
+
+type Principal struct {
+	UserId string
+	Admin  bool
+}
+
+func (p Principal) Role() Role {
+	if p.Admin {
+		return MODERATOR
+	}
+	return CLIENT
+}
+
+// Authenticator issues access tokens on login and resolves them back to
+// the principal on every protected request.
+type Authenticator interface {
+	Issue(ctx context.Context, principal Principal) (string, error)
+	// Authenticate returns ErrInvalidToken for unknown, expired or
+	// malformed tokens and other errors for infrastructure failures.
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+	// Revoke invalidates token, RevokeAll invalidates every token of the user.
+	Revoke(ctx context.Context, token string) error
+	RevokeAll(ctx context.Context, userId string) error
+}
+
+func NewAuthenticator(cfg *config.Config, c repositories.SessionStore) Authenticator {
+	switch cfg.Auth.Mode {
+	case SESSION, "":
+		return NewSessionAuthenticator(c)
+	case JWT:
+		a, err := NewJwtAuthenticator(
+			cfg.Auth.Jwt.Algorithm,
+			cfg.Auth.Jwt.Issuer,
+			cfg.Auth.Jwt.Ttl,
+			cfg.Auth.Jwt.ClockSkew,
+			cfg.Auth.Jwt.RevocationCheck,
+			cfg.Auth.Jwt.ActiveKid,
+			cfg.Auth.Jwt.Keys,
+			c,
+		)
+		if err != nil {
+			panic(err)
+		}
+		return a
+	default:
+		panic(errors.New("unknown auth mode: " + cfg.Auth.Mode))
+	}
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"bootcamp_task/config"
+	"bootcamp_task/storage/repositories"
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type jwtKey struct {
+	sign   crypto.PrivateKey
+	verify crypto.PublicKey
+}
+
+// JwtAuthenticator issues self-contained signed tokens, whose signature and
+// claims are validated locally. Every key has a kid; tokens are signed with
+// the active key and verified with the key named in their header, so keys
+// can be rotated by adding a new active key while keeping the old one for
+// verification until its tokens expire.
+//
+// Revoked tokens are kept in the denylist of the session store until they
+// expire. A token found there is not revoked is trusted for revocationCheck
+// without another lookup, so a revocation made by another instance takes
+// effect within revocationCheck; revocations made by this instance take
+// effect at once. If the denylist cannot be read, the request fails: a
+// store outage must not bring revoked tokens back.
+type JwtAuthenticator struct {
+	method          jwt.SigningMethod
+	issuer          string
+	ttl             time.Duration
+	clockSkew       time.Duration
+	revocationCheck time.Duration
+	activeKid       string
+	keys            map[string]jwtKey
+	store           repositories.SessionStore
+
+	mu sync.Mutex
+	// checked holds tokens found not revoked by jti. revocations counts
+	// Revoke and RevokeAll calls, so a lookup that raced with one of them
+	// is not cached.
+	checked     map[string]checkedToken
+	revocations int
+	lastSweep   time.Time
+}
+
+type checkedToken struct {
+	userId string
+	until  time.Time
+}
+
+type jwtClaims struct {
+	Role string `json:"role"`
+	// IssuedAtMicro repeats iat in microseconds. Issue times are compared
+	// with the time of RevokeAll, and whole seconds of iat would revoke
+	// tokens issued right after it as well.
+	IssuedAtMicro int64 `json:"iat_us"`
+	jwt.RegisteredClaims
+}
+
+func NewJwtAuthenticator(
+	algorithm string,
+	issuer string,
+	ttl int,
+	clockSkew int,
+	revocationCheck int,
+	activeKid string,
+	keys []config.JwtKey,
+	store repositories.SessionStore) (*JwtAuthenticator, error) {
+	method := jwt.GetSigningMethod(algorithm)
+	switch method {
+	case jwt.SigningMethodHS256, jwt.SigningMethodRS256, jwt.SigningMethodEdDSA:
+	default:
+		return nil, errors.New("unsupported jwt algorithm: " + algorithm)
+	}
+	a := JwtAuthenticator{
+		method:          method,
+		issuer:          issuer,
+		ttl:             time.Duration(ttl) * time.Minute,
+		clockSkew:       time.Duration(clockSkew) * time.Second,
+		revocationCheck: time.Duration(revocationCheck) * time.Second,
+		activeKid:       activeKid,
+		keys:            make(map[string]jwtKey, len(keys)),
+		store:           store,
+		checked:         make(map[string]checkedToken),
+	}
+	if a.revocationCheck <= 0 {
+		a.revocationCheck = 5 * time.Second
+	}
+	for _, k := range keys {
+		key, err := a.loadKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", k.Kid, err)
+		}
+		a.keys[k.Kid] = key
+	}
+	if active, ok := a.keys[activeKid]; !ok || active.sign == nil {
+		return nil, fmt.Errorf("jwt active key %q has no signing key", activeKid)
+	}
+	return &a, nil
+}
+
+func (a *JwtAuthenticator) loadKey(k config.JwtKey) (jwtKey, error) {
+	if a.method == jwt.SigningMethodHS256 {
+		if k.Secret == "" {
+			return jwtKey{}, errors.New("secret is required for HS256")
+		}
+		return jwtKey{sign: []byte(k.Secret), verify: []byte(k.Secret)}, nil
+	}
+	var key jwtKey
+	if k.PrivateKey != "" {
+		pem, err := os.ReadFile(k.PrivateKey)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		if a.method == jwt.SigningMethodRS256 {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return jwtKey{}, err
+			}
+			key.sign, key.verify = private, private.Public()
+		} else {
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return jwtKey{}, err
+			}
+			key.sign, key.verify = private, private.(crypto.Signer).Public()
+		}
+	}
+	if k.PublicKey != "" {
+		pem, err := os.ReadFile(k.PublicKey)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		if a.method == jwt.SigningMethodRS256 {
+			key.verify, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		} else {
+			key.verify, err = jwt.ParseEdPublicKeyFromPEM(pem)
+		}
+		if err != nil {
+			return jwtKey{}, err
+		}
+	}
+	if key.verify == nil {
+		return jwtKey{}, errors.New("private_key or public_key is required")
+	}
+	return key, nil
+}
+
+func (a *JwtAuthenticator) Issue(ctx context.Context, principal Principal) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(a.method, jwtClaims{
+		Role:          string(principal.Role()),
+		IssuedAtMicro: now.UnixMicro(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    a.issuer,
+			Subject:   principal.UserId,
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.ttl)),
+		},
+	})
+	token.Header["kid"] = a.activeKid
+	return token.SignedString(a.keys[a.activeKid].sign)
+}
+
+// parse verifies token and returns its claims. Tokens without jti or iat_us
+// cannot be revoked, so they are rejected as well.
+func (a *JwtAuthenticator) parse(token string) (*jwtClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{a.method.Alg()}),
+		jwt.WithLeeway(a.clockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if a.issuer != "" {
+		options = append(options, jwt.WithIssuer(a.issuer))
+	}
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(token, &claims, a.verificationKey, options...)
+	if err != nil || claims.ID == "" || claims.IssuedAtMicro == 0 {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+func (a *JwtAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	claims, err := a.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := a.isRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidToken
+	}
+	switch claims.Role {
+	case string(CLIENT):
+		return &Principal{UserId: claims.Subject, Admin: false}, nil
+	case string(MODERATOR):
+		return &Principal{UserId: claims.Subject, Admin: true}, nil
+	default:
+		return nil, ErrInvalidToken
+	}
+}
+
+// isRevoked looks claims up in the denylist unless the token was found not
+// revoked less than revocationCheck ago.
+func (a *JwtAuthenticator) isRevoked(ctx context.Context, claims *jwtClaims) (bool, error) {
+	now := time.Now()
+	a.mu.Lock()
+	entry, ok := a.checked[claims.ID]
+	revocations := a.revocations
+	a.mu.Unlock()
+	if ok && now.Before(entry.until) {
+		return false, nil
+	}
+	revoked, err := a.store.IsTokenRevoked(ctx, claims.ID, claims.Subject, time.UnixMicro(claims.IssuedAtMicro))
+	if err != nil || revoked {
+		return revoked, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.lastSweep) >= a.revocationCheck {
+		for jti, entry := range a.checked {
+			if !now.Before(entry.until) {
+				delete(a.checked, jti)
+			}
+		}
+		a.lastSweep = now
+	}
+	if revocations == a.revocations {
+		a.checked[claims.ID] = checkedToken{userId: claims.Subject, until: now.Add(a.revocationCheck)}
+	}
+	return false, nil
+}
+
+// forget drops the checked tokens matching revoked, so they are looked up
+// in the denylist again.
+func (a *JwtAuthenticator) forget(revoked func(jti string, entry checkedToken) bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.revocations++
+	for jti, entry := range a.checked {
+		if revoked(jti, entry) {
+			delete(a.checked, jti)
+		}
+	}
+}
+
+// Revoke puts the jti of token into the denylist until the token expires,
+// including the allowed clock skew.
+func (a *JwtAuthenticator) Revoke(ctx context.Context, token string) error {
+	claims, err := a.parse(token)
+	if err != nil {
+		return err
+	}
+	if err := a.store.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Add(a.clockSkew)); err != nil {
+		return err
+	}
+	a.forget(func(jti string, _ checkedToken) bool { return jti == claims.ID })
+	return nil
+}
+
+// RevokeAll denies every token of the user issued until now. The mark
+// outlives the last of them by the allowed clock skew.
+func (a *JwtAuthenticator) RevokeAll(ctx context.Context, userId string) error {
+	if err := a.store.RevokeUserTokens(ctx, userId, time.Now(), a.ttl+a.clockSkew); err != nil {
+		return err
+	}
+	a.forget(func(_ string, entry checkedToken) bool { return entry.userId == userId })
+	return nil
+}
+
+func (a *JwtAuthenticator) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := a.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown kid")
+	}
+	return key.verify, nil
+}
@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"bootcamp_task/config"
+	"bootcamp_task/storage/memory"
+	"bootcamp_task/storage/repositories"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testIssuer = "bootcamp_task"
+
+func newTestStore() *memory.Cache {
+	store := &memory.Cache{}
+	store.Init(10, 10, 10)
+	return store
+}
+
+// countingStore counts denylist lookups and fails them while err is set.
+type countingStore struct {
+	*memory.Cache
+	lookups int
+	err     error
+}
+
+func (s *countingStore) IsTokenRevoked(ctx context.Context, jti string, userId string, issuedAt time.Time) (bool, error) {
+	s.lookups++
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.Cache.IsTokenRevoked(ctx, jti, userId, issuedAt)
+}
+
+// writePem stores der as a PEM block in dir and returns the file path.
+func writePem(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestKey returns a key of the algorithm with kid. Asymmetric keys are
+// written to PEM files, as they are configured in config.yaml.
+func newTestKey(t *testing.T, algorithm string, kid string) config.JwtKey {
+	t.Helper()
+	key := config.JwtKey{Kid: kid}
+	var private interface{}
+	var public interface{}
+	switch algorithm {
+	case "HS256":
+		key.Secret = "secret-" + kid
+		return key
+	case "RS256":
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = k, &k.PublicKey
+	case "EdDSA":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = priv, pub
+	default:
+		t.Fatalf("unknown algorithm %s", algorithm)
+	}
+	dir := t.TempDir()
+	publicDer, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.PublicKey = writePem(t, dir, "public.pem", "PUBLIC KEY", publicDer)
+	privateDer, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.PrivateKey = writePem(t, dir, "private.pem", "PRIVATE KEY", privateDer)
+	return key
+}
+
+func newTestJwtAuthenticator(
+	t *testing.T,
+	algorithm string,
+	activeKid string,
+	keys []config.JwtKey,
+	store repositories.SessionStore) *JwtAuthenticator {
+	t.Helper()
+	a, err := NewJwtAuthenticator(algorithm, testIssuer, 10, 30, 5, activeKid, keys, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// testClaims returns the claims Issue would put into a token issued at
+// issuedAt.
+func testClaims(issuedAt time.Time, ttl time.Duration) jwtClaims {
+	return jwtClaims{
+		Role:          string(CLIENT),
+		IssuedAtMicro: issuedAt.UnixMicro(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   uuid.New().String(),
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			NotBefore: jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(ttl)),
+		},
+	}
+}
+
+// sign signs claims with the key kid of a, bypassing Issue.
+func sign(t *testing.T, a *JwtAuthenticator, kid string, claims jwtClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(a.method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(a.keys[kid].sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func expectValid(t *testing.T, a *JwtAuthenticator, token string) *Principal {
+	t.Helper()
+	principal, err := a.Authenticate(context.Background(), token)
+	if err != nil {
+		t.Fatalf("expected a valid token, got %v", err)
+	}
+	return principal
+}
+
+func expectInvalid(t *testing.T, a *JwtAuthenticator, token string) {
+	t.Helper()
+	_, err := a.Authenticate(context.Background(), token)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestJwtAlgorithms(t *testing.T) {
+	for _, algorithm := range []string{"HS256", "RS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			keys := []config.JwtKey{newTestKey(t, algorithm, "1")}
+			a := newTestJwtAuthenticator(t, algorithm, "1", keys, newTestStore())
+			for _, want := range []Principal{{UserId: uuid.New().String(), Admin: false}, {UserId: uuid.New().String(), Admin: true}} {
+				token, err := a.Issue(context.Background(), want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := expectValid(t, a, token)
+				if *got != want {
+					t.Fatalf("expected principal %v, got %v", want, *got)
+				}
+			}
+			expectInvalid(t, a, "not.a.token")
+		})
+	}
+}
+
+func TestJwtKeyRotation(t *testing.T) {
+	for _, algorithm := range []string{"HS256", "RS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			store := newTestStore()
+			oldKey := newTestKey(t, algorithm, "1")
+			newKey := newTestKey(t, algorithm, "2")
+			before := newTestJwtAuthenticator(t, algorithm, "1", []config.JwtKey{oldKey}, store)
+			oldToken, err := before.Issue(context.Background(), Principal{UserId: uuid.New().String()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The old key stays for verification only, public half is enough.
+			oldVerifyKey := oldKey
+			if algorithm != "HS256" {
+				oldVerifyKey.PrivateKey = ""
+			}
+			rotated := newTestJwtAuthenticator(t, algorithm, "2", []config.JwtKey{oldVerifyKey, newKey}, store)
+			expectValid(t, rotated, oldToken)
+			newToken, err := rotated.Issue(context.Background(), Principal{UserId: uuid.New().String()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectValid(t, rotated, newToken)
+
+			retired := newTestJwtAuthenticator(t, algorithm, "2", []config.JwtKey{newKey}, store)
+			expectInvalid(t, retired, oldToken)
+			expectValid(t, retired, newToken)
+
+			// A valid signature does not help a token naming an unknown kid.
+			unknown := jwt.NewWithClaims(rotated.method, testClaims(time.Now(), time.Minute))
+			unknown.Header["kid"] = "3"
+			signed, err := unknown.SignedString(rotated.keys["2"].sign)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectInvalid(t, rotated, signed)
+		})
+	}
+}
+
+func TestJwtRejectsOtherAlgorithms(t *testing.T) {
+	rsaKey := newTestKey(t, "RS256", "1")
+	a := newTestJwtAuthenticator(t, "RS256", "1", []config.JwtKey{rsaKey}, newTestStore())
+	publicPem, err := os.ReadFile(rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := testClaims(time.Now(), time.Minute)
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		key    interface{}
+	}{
+		// The public key is known to everyone, it must not work as an HMAC
+		// secret.
+		{"HS256 with the public key", jwt.SigningMethodHS256, publicPem},
+		{"RS512", jwt.SigningMethodRS512, a.keys["1"].sign},
+		{"none", jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(tt.method, claims)
+			token.Header["kid"] = "1"
+			signed, err := token.SignedString(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectInvalid(t, a, signed)
+		})
+	}
+}
+
+func TestJwtClockSkew(t *testing.T) {
+	a := newTestJwtAuthenticator(t, "HS256", "1", []config.JwtKey{newTestKey(t, "HS256", "1")}, newTestStore())
+	now := time.Now()
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		ttl      time.Duration
+		valid    bool
+	}{
+		{"issued by a clock ahead within the skew", now.Add(20 * time.Second), time.Minute, true},
+		{"issued by a clock ahead beyond the skew", now.Add(time.Minute), time.Minute, false},
+		{"expired within the skew", now.Add(-time.Minute), 40 * time.Second, true},
+		{"expired beyond the skew", now.Add(-2 * time.Minute), time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := sign(t, a, "1", testClaims(tt.issuedAt, tt.ttl))
+			if tt.valid {
+				expectValid(t, a, token)
+			} else {
+				expectInvalid(t, a, token)
+			}
+		})
+	}
+}
+
+func TestJwtRequiredClaims(t *testing.T) {
+	a := newTestJwtAuthenticator(t, "HS256", "1", []config.JwtKey{newTestKey(t, "HS256", "1")}, newTestStore())
+	tests := []struct {
+		name   string
+		modify func(claims *jwtClaims)
+	}{
+		{"without jti", func(claims *jwtClaims) { claims.ID = "" }},
+		{"without iat_us", func(claims *jwtClaims) { claims.IssuedAtMicro = 0 }},
+		{"without exp", func(claims *jwtClaims) { claims.ExpiresAt = nil }},
+		{"of another issuer", func(claims *jwtClaims) { claims.Issuer = "someone" }},
+		{"with an unknown role", func(claims *jwtClaims) { claims.Role = "admin" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims(time.Now(), time.Minute)
+			tt.modify(&claims)
+			expectInvalid(t, a, sign(t, a, "1", claims))
+		})
+	}
+}
+
+func TestJwtRevocation(t *testing.T) {
+	a := newTestJwtAuthenticator(t, "HS256", "1", []config.JwtKey{newTestKey(t, "HS256", "1")}, newTestStore())
+	ctx := context.Background()
+	principal := Principal{UserId: uuid.New().String()}
+	first, _ := a.Issue(ctx, principal)
+	second, _ := a.Issue(ctx, principal)
+
+	if err := a.Revoke(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	expectInvalid(t, a, first)
+	expectValid(t, a, second)
+
+	if err := a.RevokeAll(ctx, principal.UserId); err != nil {
+		t.Fatal(err)
+	}
+	expectInvalid(t, a, second)
+	// Issued within the same second as RevokeAll, but after it.
+	time.Sleep(time.Millisecond)
+	third, _ := a.Issue(ctx, principal)
+	expectValid(t, a, third)
+}
+
+func TestJwtDenylistLookups(t *testing.T) {
+	store := &countingStore{Cache: newTestStore()}
+	keys := []config.JwtKey{newTestKey(t, "HS256", "1")}
+	// Two instances of the service sharing the session store.
+	a := newTestJwtAuthenticator(t, "HS256", "1", keys, store)
+	b := newTestJwtAuthenticator(t, "HS256", "1", keys, store)
+	a.revocationCheck = 100 * time.Millisecond
+	ctx := context.Background()
+	principal := Principal{UserId: uuid.New().String()}
+
+	token, _ := a.Issue(ctx, principal)
+	expectValid(t, a, token)
+	expectValid(t, a, token)
+	if store.lookups != 1 {
+		t.Fatalf("expected a single denylist lookup, got %d", store.lookups)
+	}
+
+	// A revocation by another instance is seen once the check expires.
+	if err := b.Revoke(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	expectValid(t, a, token)
+	time.Sleep(a.revocationCheck)
+	expectInvalid(t, a, token)
+
+	// A revocation by the same instance is seen at once.
+	own, _ := a.Issue(ctx, principal)
+	expectValid(t, a, own)
+	if err := a.RevokeAll(ctx, principal.UserId); err != nil {
+		t.Fatal(err)
+	}
+	expectInvalid(t, a, own)
+
+	// An unreadable denylist fails the request.
+	store.err = errors.New("store is down")
+	time.Sleep(time.Millisecond)
+	fresh, _ := a.Issue(ctx, principal)
+	if _, err := a.Authenticate(ctx, fresh); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected the store error, got %v", err)
+	}
+}
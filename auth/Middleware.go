@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	principalKey = "principal"
+	tokenKey     = "token"
+)
+
+// Middleware resolves the principal from the "Authorization: Bearer" header
+// or from the legacy "auth" header and stores it in the request locals.
+// Requests without a valid token fail with ErrInvalidToken.
+func Middleware(a Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := extractToken(c)
+		if token == "" {
+			return ErrInvalidToken
+		}
+		principal, err := a.Authenticate(c.UserContext(), token)
+		if err != nil {
+			return err
+		}
+		c.Locals(principalKey, principal)
+		c.Locals(tokenKey, token)
+		return c.Next()
+	}
+}
+
+// RequireRole rejects with ErrForbidden requests whose principal has none
+// of roles. It must be registered after Middleware.
+func RequireRole(roles ...Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := GetPrincipal(c)
+		if principal == nil {
+			return ErrInvalidToken
+		}
+		for _, role := range roles {
+			if principal.Role() == role {
+				return c.Next()
+			}
+		}
+		return ErrForbidden
+	}
+}
+
+// GetPrincipal returns the principal resolved by Middleware or nil.
+func GetPrincipal(c *fiber.Ctx) *Principal {
+	principal, _ := c.Locals(principalKey).(*Principal)
+	return principal
+}
+
+// GetToken returns the access token the principal was resolved from.
+func GetToken(c *fiber.Ctx) string {
+	token, _ := c.Locals(tokenKey).(string)
+	return token
+}
+
+func extractToken(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+	return c.Get("auth")
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"bootcamp_task/storage/repositories"
+	"context"
+	"errors"
+)
+
+// SessionAuthenticator keeps sessions in the session store (Redis or
+// memory); tokens are opaque session ids.
+type SessionAuthenticator struct {
+	cache repositories.SessionStore
+}
+
+func NewSessionAuthenticator(c repositories.SessionStore) *SessionAuthenticator {
+	return &SessionAuthenticator{cache: c}
+}
+
+func (s *SessionAuthenticator) Issue(ctx context.Context, principal Principal) (string, error) {
+	return s.cache.CreateSession(ctx, principal.UserId, principal.Admin)
+}
+
+func (s *SessionAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	userId, admin, err := s.cache.GetSession(ctx, token)
+	if errors.Is(err, repositories.ErrSessionNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{UserId: userId, Admin: admin}, nil
+}
+
+func (s *SessionAuthenticator) Revoke(ctx context.Context, token string) error {
+	return s.cache.DeleteSession(ctx, token)
+}
+
+func (s *SessionAuthenticator) RevokeAll(ctx context.Context, userId string) error {
+	return s.cache.DeleteUserSessions(ctx, userId)
+}
@@ -3,6 +3,7 @@ package cache
 import (
 	"bootcamp_task/config"
 	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/repositories"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,10 +12,16 @@ import (
 	"time"
 )
 
+var (
+	_ repositories.SessionStore = (*Cache)(nil)
+	_ repositories.FlatsCache   = (*Cache)(nil)
+)
+
 type Cache struct {
 	rCl              *redis.Client
 	timeout          time.Duration
 	sessionTimeout   time.Duration
+	refreshTimeout   time.Duration
 	flatCacheTimeout time.Duration
 }
 
@@ -25,8 +32,10 @@ func NewCache(cfg *config.Config) *Cache {
 		cfg.Redis.Password,
 		cfg.Redis.PoolSize,
 		cfg.Redis.Timeout,
+		cfg.Redis.OperationTimeout,
 		cfg.Redis.IdleTimeOut,
 		cfg.Redis.SessionTimeout,
+		cfg.Redis.RefreshTimeout,
 		cfg.Redis.FlatCacheTimeout,
 	)
 	if err != nil {
@@ -40,8 +49,10 @@ func (c *Cache) Init(
 	password string,
 	poolSize int,
 	timeout int,
+	operationTimeout int,
 	idleTimeout int,
 	sessionTimeout int,
+	refreshTimeout int,
 	flatCacheTimeout int) error {
 	c.rCl = redis.NewClient(&redis.Options{
 		Addr:         host,
@@ -52,7 +63,12 @@ func (c *Cache) Init(
 		ReadTimeout:  time.Duration(timeout) * time.Millisecond,
 		WriteTimeout: time.Duration(timeout) * time.Millisecond,
 	})
+	c.timeout = time.Duration(operationTimeout) * time.Millisecond
+	if c.timeout <= 0 {
+		c.timeout = 100 * time.Millisecond
+	}
 	c.sessionTimeout = time.Duration(sessionTimeout) * time.Minute
+	c.refreshTimeout = time.Duration(refreshTimeout) * time.Minute
 	c.flatCacheTimeout = time.Duration(flatCacheTimeout) * time.Minute
 	_, err := c.rCl.Ping(context.Background()).Result()
 	if err != nil {
@@ -61,9 +77,7 @@ func (c *Cache) Init(
 	return nil
 }
 
-func (c *Cache) getConnection() *redis.Conn {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
-	defer cancel()
+func (c *Cache) getConnection(ctx context.Context) *redis.Conn {
 	return c.rCl.Conn(ctx)
 }
 
@@ -73,32 +87,104 @@ type userSession struct {
 }
 
 func (c *Cache) CreateSession(
+	ctx context.Context,
 	userId string,
 	admin bool) (string, error) {
-	conn := c.getConnection()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
 	defer conn.Close()
-	uid := uuid.New()
+	j, err := json.Marshal(userSession{
+		userId,
+		admin,
+	})
+	if err != nil {
+		return "", err
+	}
+	// SetNX claims the id atomically, a collision only costs another try.
+	var uid string
 	for {
-		_, err := conn.Get(context.Background(), uid.String()).Result()
-		if errors.Is(err, redis.Nil) {
+		uid = uuid.New().String()
+		created, err := conn.SetNX(ctx, uid, j, c.sessionTimeout).Result()
+		if err != nil {
+			return "", err
+		}
+		if created {
 			break
 		}
-		uid = uuid.New()
 	}
-	j, err := json.Marshal(userSession{
-		userId,
-		admin,
+	if userId == "" {
+		return uid, nil
+	}
+	_, err = conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, userSessionsKey(userId), uid)
+		pipe.Expire(ctx, userSessionsKey(userId), c.sessionTimeout)
+		return nil
 	})
 	if err != nil {
+		// A session missing from the user's set would survive DELETE /sessions.
+		conn.Del(ctx, uid)
 		return "", err
 	}
-	return uid.String(), conn.Set(context.Background(), uid.String(), j, c.sessionTimeout).Err()
+	return uid, nil
 }
 
-func (c *Cache) GetSession(id string) (string, bool, error) {
-	conn := c.getConnection()
+// userSessionsKey names the set of session ids of the user. It may contain
+// ids of already expired sessions, deleting them is a no-op.
+func userSessionsKey(userId string) string {
+	return "user_sessions:" + userId
+}
+
+func (c *Cache) DeleteSession(ctx context.Context, id string) error {
+	userId, _, err := c.GetSession(ctx, id)
+	if errors.Is(err, repositories.ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
 	defer conn.Close()
-	value, err := conn.Get(context.Background(), id).Result()
+	_, err = conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, id)
+		if userId != "" {
+			pipe.SRem(ctx, userSessionsKey(userId), id)
+		}
+		return nil
+	})
+	return err
+}
+
+func (c *Cache) DeleteUserSessions(ctx context.Context, userId string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	ids, err := conn.SMembers(ctx, userSessionsKey(userId)).Result()
+	if err != nil {
+		return err
+	}
+	_, err = conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(ids) > 0 {
+			pipe.Del(ctx, ids...)
+		}
+		pipe.Del(ctx, userSessionsKey(userId))
+		return nil
+	})
+	return err
+}
+
+func (c *Cache) GetSession(ctx context.Context, id string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	value, err := conn.Get(ctx, id).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, repositories.ErrSessionNotFound
+	}
 	if err != nil {
 		return "", false, err
 	}
@@ -109,26 +195,33 @@ func (c *Cache) GetSession(id string) (string, bool, error) {
 	return response.Uid, response.Admin, nil
 }
 
-func (c *Cache) GetFlatsCache(cacheId string) ([]entities.Flat, error) {
-	conn := c.getConnection()
+func (c *Cache) GetFlatsCache(ctx context.Context, cacheId string) (*entities.FlatPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
 	defer conn.Close()
-	value, err := conn.Get(context.Background(), cacheId).Result()
+	value, err := conn.Get(ctx, cacheId).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, repositories.ErrNotCached
+	}
 	if err != nil {
-		return []entities.Flat{}, err
+		return nil, err
 	}
-	var response []entities.Flat
+	var response entities.FlatPage
 	if err := json.Unmarshal([]byte(value), &response); err != nil {
-		return []entities.Flat{}, err
+		return nil, err
 	}
-	return response, nil
+	return &response, nil
 }
 
-func (c *Cache) PutFlatsCache(cacheId string, flats []entities.Flat) error {
-	body, err := json.Marshal(flats)
+func (c *Cache) PutFlatsCache(ctx context.Context, cacheId string, page *entities.FlatPage) error {
+	body, err := json.Marshal(page)
 	if err != nil {
 		return err
 	}
-	conn := c.getConnection()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
 	defer conn.Close()
-	return conn.Set(context.Background(), cacheId, body, c.flatCacheTimeout).Err()
+	return conn.Set(ctx, cacheId, body, c.flatCacheTimeout).Err()
 }
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"bootcamp_task/storage/errs"
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRefreshTokenInvalid  = errs.Unauthorized("invalid_refresh_token", "refresh token is invalid or expired")
+	ErrRefreshTokenReused   = errs.Unauthorized("refresh_token_reused", "refresh token was already used, please log in again")
+	ErrRefreshTokenNotOwned = errs.Forbidden("refresh_token_not_owned", "refresh token was issued to another user")
+)
+
+// Refresh tokens are single use: every rotation marks the presented token
+// as used and issues a new one of the same family. A used token presented
+// again means it was stolen, so the whole family is revoked.
+
+// useRefreshToken marks the token used and returns whether it was unused
+// before, its uid, admin and family, or nil for a missing token. Checking
+// and marking happen atomically, so a token that expires in between is not
+// recreated. A used token is kept for ARGV[1] milliseconds, as long as its
+// family, to detect its reuse.
+var useRefreshToken = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+local first = redis.call('HSETNX', KEYS[1], 'used', '1')
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+local values = redis.call('HMGET', KEYS[1], 'uid', 'admin', 'family')
+return {first, values[1], values[2], values[3]}
+`)
+
+func refreshTokenKey(token string) string {
+	return "refresh:" + token
+}
+
+func refreshFamilyKey(family string) string {
+	return "refresh_family:" + family
+}
+
+func userRefreshKey(userId string) string {
+	return "user_refresh:" + userId
+}
+
+func (c *Cache) CreateRefreshToken(ctx context.Context, userId string, admin bool) (string, error) {
+	return c.createRefreshToken(ctx, userId, admin, uuid.New().String())
+}
+
+func (c *Cache) createRefreshToken(ctx context.Context, userId string, admin bool, family string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	token := uuid.New().String()
+	_, err := conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, refreshTokenKey(token),
+			"uid", userId,
+			"admin", strconv.FormatBool(admin),
+			"family", family,
+		)
+		pipe.Expire(ctx, refreshTokenKey(token), c.refreshTimeout)
+		pipe.SAdd(ctx, refreshFamilyKey(family), token)
+		pipe.Expire(ctx, refreshFamilyKey(family), c.refreshTimeout)
+		pipe.SAdd(ctx, userRefreshKey(userId), family)
+		pipe.Expire(ctx, userRefreshKey(userId), c.refreshTimeout)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken exchanges token for a new refresh token of the same
+// family. It returns ErrRefreshTokenInvalid for unknown or expired tokens
+// and ErrRefreshTokenReused if token was already rotated.
+func (c *Cache) RotateRefreshToken(ctx context.Context, token string) (userId string, admin bool, newToken string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	result, err := useRefreshToken.Run(ctx, conn, []string{refreshTokenKey(token)}, c.refreshTimeout.Milliseconds()).Slice()
+	if errors.Is(err, redis.Nil) {
+		return "", false, "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return "", false, "", err
+	}
+	first, _ := result[0].(int64)
+	uid, _ := result[1].(string)
+	family, _ := result[3].(string)
+	if first == 0 {
+		if err := c.deleteRefreshFamily(ctx, family); err != nil {
+			return "", false, "", err
+		}
+		return "", false, "", ErrRefreshTokenReused
+	}
+	adminValue, _ := result[2].(string)
+	admin, _ = strconv.ParseBool(adminValue)
+	newToken, err = c.createRefreshToken(ctx, uid, admin, family)
+	if err != nil {
+		return "", false, "", err
+	}
+	return uid, admin, newToken, nil
+}
+
+// DeleteRefreshToken revokes the family of token if it was issued to
+// userId. Unknown tokens are ignored.
+func (c *Cache) DeleteRefreshToken(ctx context.Context, token string, userId string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	values, err := conn.HMGet(ctx, refreshTokenKey(token), "uid", "family").Result()
+	if err != nil {
+		return err
+	}
+	uid, _ := values[0].(string)
+	family, _ := values[1].(string)
+	if family == "" {
+		return nil
+	}
+	if uid != userId {
+		return ErrRefreshTokenNotOwned
+	}
+	return c.deleteRefreshFamily(ctx, family)
+}
+
+func (c *Cache) DeleteUserRefreshTokens(ctx context.Context, userId string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	families, err := conn.SMembers(ctx, userRefreshKey(userId)).Result()
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if err := c.deleteRefreshFamily(ctx, family); err != nil {
+			return err
+		}
+	}
+	return conn.Del(ctx, userRefreshKey(userId)).Err()
+}
+
+func (c *Cache) deleteRefreshFamily(ctx context.Context, family string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	tokens, err := conn.SMembers(ctx, refreshFamilyKey(family)).Result()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(tokens)+1)
+	for _, token := range tokens {
+		keys = append(keys, refreshTokenKey(token))
+	}
+	keys = append(keys, refreshFamilyKey(family))
+	return conn.Del(ctx, keys...).Err()
+}
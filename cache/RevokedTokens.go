@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Access tokens of the jwt mode are stateless, so revoking them means
+// remembering them until they expire: a single token by its jti, all tokens
+// of a user by the time they were revoked at. Issue times are compared in
+// microseconds, the precision of the iat_us claim.
+
+func revokedTokenKey(jti string) string {
+	return "revoked_token:" + jti
+}
+
+func revokedUserKey(userId string) string {
+	return "revoked_user:" + userId
+}
+
+func (c *Cache) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	return conn.Set(ctx, revokedTokenKey(jti), "1", ttl).Err()
+}
+
+func (c *Cache) RevokeUserTokens(ctx context.Context, userId string, before time.Time, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	return conn.Set(ctx, revokedUserKey(userId), before.UnixMicro(), ttl).Err()
+}
+
+func (c *Cache) IsTokenRevoked(ctx context.Context, jti string, userId string, issuedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn := c.getConnection(ctx)
+	defer conn.Close()
+	var revoked *redis.IntCmd
+	var before *redis.StringCmd
+	_, err := conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		revoked = pipe.Exists(ctx, revokedTokenKey(jti))
+		if userId != "" {
+			before = pipe.Get(ctx, revokedUserKey(userId))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+	if revoked.Val() > 0 {
+		return true, nil
+	}
+	if before == nil || errors.Is(before.Err(), redis.Nil) {
+		return false, nil
+	}
+	micros, err := strconv.ParseInt(before.Val(), 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.UnixMicro() <= micros, nil
+}
@@ -8,27 +8,83 @@ import (
 )
 
 type Config struct {
-	ServerPort int `yaml:"server_port"`
-	Postgres   struct {
-		User               string `yaml:"user"`
-		Database           string `yaml:"database"`
-		SSLMode            bool   `yaml:"ssl_mode"`
-		Password           string `yaml:"password"`
-		Host               string `yaml:"host"`
-		Port               int    `yaml:"port"`
-		DataBaseTimeout    int    `yaml:"database_timeout"`
-		MaxConnections     int    `yaml:"max_connections"`
-		MaxIdleConnections int    `yaml:"max_idle_connections"`
+	ServerPort     int `yaml:"server_port"`
+	RequestTimeout int `yaml:"request_timeout"`
+	Postgres       struct {
+		User                   string `yaml:"user"`
+		Database               string `yaml:"database"`
+		SSLMode                bool   `yaml:"ssl_mode"`
+		Password               string `yaml:"password"`
+		Host                   string `yaml:"host"`
+		Port                   int    `yaml:"port"`
+		DataBaseTimeout        int    `yaml:"database_timeout"`
+		SearchTimeout          int    `yaml:"search_timeout"`
+		MaxConnections         int    `yaml:"max_connections"`
+		StatementCacheCapacity int    `yaml:"statement_cache_capacity"`
 	} `yaml:"postgres"`
 	Redis struct {
 		Host             string `yaml:"host"`
 		Password         string `yaml:"password"`
 		PoolSize         int    `yaml:"pool_size"`
 		Timeout          int    `yaml:"timeout"`
+		OperationTimeout int    `yaml:"operation_timeout"`
 		IdleTimeOut      int    `yaml:"idle_timeout"`
 		SessionTimeout   int    `yaml:"session_timeout"`
+		RefreshTimeout   int    `yaml:"refresh_timeout"`
 		FlatCacheTimeout int    `yaml:"flat_cache_timeout"`
 	} `yaml:"redis"`
+	Moderation struct {
+		ClaimTimeout     float64 `yaml:"claim_timeout"`
+		MaxResubmissions int     `yaml:"max_resubmissions"`
+	} `yaml:"moderation"`
+	Notifications struct {
+		Sender      string `yaml:"sender"`
+		FilePath    string `yaml:"file_path"`
+		Workers     int    `yaml:"workers"`
+		QueueSize   int    `yaml:"queue_size"`
+		MaxRetries  int    `yaml:"max_retries"`
+		RetryDelay  int    `yaml:"retry_delay"`
+		SendTimeout int    `yaml:"send_timeout"`
+		Smtp        struct {
+			Host     string `yaml:"host"`
+			Port     int    `yaml:"port"`
+			User     string `yaml:"user"`
+			Password string `yaml:"password"`
+			From     string `yaml:"from"`
+		} `yaml:"smtp"`
+	} `yaml:"notifications"`
+	Outbox struct {
+		PollInterval int `yaml:"poll_interval"`
+		BatchSize    int `yaml:"batch_size"`
+		MaxAttempts  int `yaml:"max_attempts"`
+		Timeout      int `yaml:"timeout"`
+	} `yaml:"outbox"`
+	Passwords struct {
+		Algorithm     string `yaml:"algorithm"`
+		BcryptCost    int    `yaml:"bcrypt_cost"`
+		Argon2Memory  int    `yaml:"argon2_memory"`
+		Argon2Time    int    `yaml:"argon2_time"`
+		Argon2Threads int    `yaml:"argon2_threads"`
+	} `yaml:"passwords"`
+	Auth struct {
+		Mode string `yaml:"mode"`
+		Jwt  struct {
+			Algorithm       string   `yaml:"algorithm"`
+			Issuer          string   `yaml:"issuer"`
+			Ttl             int      `yaml:"ttl"`
+			ClockSkew       int      `yaml:"clock_skew"`
+			RevocationCheck int      `yaml:"revocation_check"`
+			ActiveKid       string   `yaml:"active_kid"`
+			Keys            []JwtKey `yaml:"keys"`
+		} `yaml:"jwt"`
+	} `yaml:"auth"`
+}
+
+type JwtKey struct {
+	Kid        string `yaml:"kid"`
+	Secret     string `yaml:"secret"`
+	PrivateKey string `yaml:"private_key"`
+	PublicKey  string `yaml:"public_key"`
 }
 
 func ParseConfig() *Config {
@@ -36,7 +92,13 @@ func ParseConfig() *Config {
 	if err != nil {
 		panic(err)
 	}
-	filename, err := filepath.Abs(filepath.Join(currentDir, "config.yaml"))
+	return ParseConfigFile(filepath.Join(currentDir, "config.yaml"))
+}
+
+// ParseConfigFile reads the config from path, e.g. the test config in
+// handlers/config.yaml.
+func ParseConfigFile(path string) *Config {
+	filename, err := filepath.Abs(path)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,26 @@
+package events
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+
+	"go.uber.org/fx"
+)
+
+// Consumer receives outbox events. Delivery is at-least-once: an event is
+// redelivered to every consumer until all of them handle it without error,
+// so Consume must be idempotent.
+type Consumer interface {
+	Name() string
+	Consume(ctx context.Context, event entities.OutboxEvent) error
+}
+
+// AsConsumer annotates constructor so that its result is registered as an
+// outbox consumer of the Dispatcher.
+func AsConsumer(constructor interface{}) interface{} {
+	return fx.Annotate(
+		constructor,
+		fx.As(new(Consumer)),
+		fx.ResultTags(`group:"consumers"`),
+	)
+}
@@ -0,0 +1,146 @@
+package events
+
+import (
+	"bootcamp_task/config"
+	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/repositories"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// Dispatcher polls the outbox and publishes undelivered events to the
+// registered consumers.
+type Dispatcher struct {
+	storage      repositories.OutboxRepository
+	consumers    []Consumer
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	timeout      time.Duration
+	ctx          context.Context
+	cancel       func()
+	wg           sync.WaitGroup
+}
+
+type DispatcherParams struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Config    *config.Config
+	Storage   repositories.OutboxRepository
+	Consumers []Consumer `group:"consumers"`
+}
+
+func NewDispatcher(p DispatcherParams) *Dispatcher {
+	d := &Dispatcher{}
+	d.Init(
+		p.Storage,
+		p.Consumers,
+		p.Config.Outbox.PollInterval,
+		p.Config.Outbox.BatchSize,
+		p.Config.Outbox.MaxAttempts,
+		p.Config.Outbox.Timeout,
+	)
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			d.Start()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			d.Stop()
+			return nil
+		},
+	})
+	return d
+}
+
+func (d *Dispatcher) Init(
+	storage repositories.OutboxRepository,
+	consumers []Consumer,
+	pollInterval int,
+	batchSize int,
+	maxAttempts int,
+	timeout int) {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 10
+	}
+	d.storage = storage
+	d.consumers = consumers
+	d.pollInterval = time.Duration(pollInterval) * time.Millisecond
+	if d.pollInterval <= 0 {
+		d.pollInterval = time.Second
+	}
+	d.batchSize = batchSize
+	d.maxAttempts = maxAttempts
+	d.timeout = time.Duration(timeout) * time.Millisecond
+	if d.timeout <= 0 {
+		d.timeout = 5 * time.Second
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+}
+
+func (d *Dispatcher) Start() {
+	d.wg.Add(1)
+	go d.run()
+}
+
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatchAll()
+		}
+	}
+}
+
+// dispatchAll drains the outbox while full batches are delivered. Failed
+// events are left for the next tick, so they are not retried in a hot loop.
+func (d *Dispatcher) dispatchAll() {
+	for d.ctx.Err() == nil {
+		delivered, err := d.dispatchBatch()
+		if err != nil {
+			log.Printf("failed to dispatch outbox events: %v", err)
+			return
+		}
+		if delivered < d.batchSize {
+			return
+		}
+	}
+}
+
+// dispatchBatch delivers one batch; the transaction holding the batch and
+// the consumers are limited by the outbox timeout.
+func (d *Dispatcher) dispatchBatch() (int, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.timeout)
+	defer cancel()
+	return d.storage.DispatchOutbox(ctx, d.batchSize, d.maxAttempts, d.publish)
+}
+
+func (d *Dispatcher) publish(ctx context.Context, event entities.OutboxEvent) error {
+	var result error
+	for _, consumer := range d.consumers {
+		if err := consumer.Consume(ctx, event); err != nil {
+			log.Printf("consumer %s failed to handle event %d: %v", consumer.Name(), event.Id, err)
+			result = errors.Join(result, err)
+		}
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bootcamp_task/storage/errs"
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"strconv"
+)
+
+// retryAfter is sent with 5xx responses, in seconds.
+const retryAfter = 5
+
+var (
+	errBadRequest = errs.Validation("bad_request", "bad request")
+	errBadParam   = errs.Validation("bad_request", "invalid path parameter")
+)
+
+type errorResponse struct {
+	Message   string            `json:"message"`
+	Code      string            `json:"code"`
+	RequestId string            `json:"request_id"`
+	Fields    []errs.FieldError `json:"fields,omitempty"`
+}
+
+var kindStatuses = map[errs.Kind]int{
+	errs.NOT_FOUND:    fiber.StatusNotFound,
+	errs.CONFLICT:     fiber.StatusConflict,
+	errs.VALIDATION:   fiber.StatusBadRequest,
+	errs.UNAUTHORIZED: fiber.StatusUnauthorized,
+	errs.FORBIDDEN:    fiber.StatusForbidden,
+}
+
+// ErrorHandler is the single place where errors returned by handlers and
+// middlewares are turned into responses. Domain errors keep their message
+// and code, anything else is logged and reported as a 5xx.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	response := errorResponse{RequestId: requestId(c)}
+	status := fiber.StatusInternalServerError
+
+	var domainErr *errs.Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &domainErr):
+		status = kindStatuses[domainErr.Kind]
+		response.Message = domainErr.Message
+		response.Code = domainErr.Code
+		response.Fields = domainErr.Fields
+	case errors.As(err, &fiberErr):
+		status = fiberErr.Code
+		response.Message = fiberErr.Message
+		response.Code = "http_" + strconv.Itoa(fiberErr.Code)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		status = fiber.StatusServiceUnavailable
+		response.Message = "service temporarily unavailable"
+		response.Code = "timeout"
+	default:
+		response.Message = "internal server error"
+		response.Code = "internal_error"
+	}
+
+	if status >= fiber.StatusInternalServerError {
+		log.Printf("request %s failed: %v", response.RequestId, err)
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	}
+	return c.Status(status).JSON(response)
+}
+
+func requestId(c *fiber.Ctx) string {
+	if id, ok := c.Locals("requestid").(string); ok {
+		return id
+	}
+	return c.GetRespHeader(fiber.HeaderXRequestID)
+}
+
+// parseBody decodes the request body into req and validates it.
+func (h *Handlers) parseBody(c *fiber.Ctx, req interface{}) error {
+	if err := c.BodyParser(req); err != nil {
+		return errBadRequest.WithMessage("request body is malformed").Wrap(err)
+	}
+	return h.validate(c, req)
+}
+
+func paramInt(c *fiber.Ctx, name string) (int, error) {
+	value, err := strconv.Atoi(c.Params(name))
+	if err != nil {
+		return 0, errBadParam.WithMessage("path parameter " + name + " must be an integer").Wrap(err)
+	}
+	return value, nil
+}
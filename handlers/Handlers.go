@@ -1,28 +1,61 @@
 package handlers
 
 import (
-	"bootcamp_task/cache"
+	"bootcamp_task/auth"
+	"bootcamp_task/passwords"
 	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/errs"
+	"bootcamp_task/storage/repositories"
 	"bootcamp_task/storage/storages"
-	"database/sql"
+	"context"
 	"errors"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
-	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
+	"log"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidUserType = errs.Validation("invalid_user_type", "user_type must be client or moderator")
+	errInvalidStatus   = errs.Validation("invalid_status", "status must be one of created, on_moderation, approved, declined")
+	errWrongPassword   = errs.Unauthorized("wrong_password", "wrong password")
 )
 
 type Handlers struct {
-	cache     *cache.Cache
-	storage   *storages.Storage
-	validator *validator.Validate
+	houses     repositories.HouseRepository
+	flats      repositories.FlatRepository
+	users      repositories.UserRepository
+	sessions   repositories.SessionStore
+	flatsCache repositories.FlatsCache
+	hasher     *passwords.Hasher
+	auth       auth.Authenticator
+	validator  *validator.Validate
+	translator *ut.UniversalTranslator
 }
 
-func NewHandlers(cache *cache.Cache, storage *storages.Storage) *Handlers {
+func NewHandlers(
+	houses repositories.HouseRepository,
+	flats repositories.FlatRepository,
+	users repositories.UserRepository,
+	sessions repositories.SessionStore,
+	flatsCache repositories.FlatsCache,
+	hasher *passwords.Hasher,
+	authenticator auth.Authenticator) *Handlers {
+	v, translator := newValidator()
 	h := Handlers{
-		cache,
-		storage,
-		validator.New(),
+		houses,
+		flats,
+		users,
+		sessions,
+		flatsCache,
+		hasher,
+		authenticator,
+		v,
+		translator,
 	}
 	return &h
 }
@@ -34,7 +67,7 @@ func (h *Handlers) getUserType(value string) (bool, error) {
 	case "moderator":
 		return true, nil
 	default:
-		return false, errors.New("invalid user type")
+		return false, errInvalidUserType
 	}
 }
 
@@ -42,11 +75,11 @@ func (h *Handlers) DummyLogin(c *fiber.Ctx) error {
 	value := c.Query("user_type")
 	admin, err := h.getUserType(value)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+		return err
 	}
-	token, err := h.cache.CreateSession("", admin)
+	token, err := h.auth.Issue(c.UserContext(), auth.Principal{UserId: "", Admin: admin})
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token})
 }
@@ -59,23 +92,20 @@ type registerRequest struct {
 
 func (h *Handlers) Register(c *fiber.Ctx) error {
 	var req registerRequest
-	err := c.BodyParser(&req)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
-	}
-	if err := h.validator.Struct(req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
-	}
-	if _, err := h.storage.GetUser(req.Email); errors.Is(err, nil) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user with same email already exists"})
+	if err := h.parseBody(c, &req); err != nil {
+		return err
 	}
 	admin, err := h.getUserType(req.UserType)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+		return err
+	}
+	hash, err := h.hasher.Hash(req.Password)
+	if err != nil {
+		return err
 	}
-	uid, err := h.storage.CreateUser(req.Email, req.Password, admin)
+	uid, err := h.users.CreateUser(c.UserContext(), req.Email, hash, admin)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"user_id": uid})
 }
@@ -87,39 +117,107 @@ type loginRequest struct {
 
 func (h *Handlers) Login(c *fiber.Ctx) error {
 	var req loginRequest
-	err := c.BodyParser(&req)
+	if err := h.parseBody(c, &req); err != nil {
+		return err
+	}
+	user, err := h.users.GetUser(c.UserContext(), req.Email)
+	if err != nil {
+		return err
+	}
+	ok, needsRehash, err := h.hasher.Verify(user.Password, req.Password)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+		return err
+	}
+	if !ok {
+		return errWrongPassword
 	}
-	if err := h.validator.Struct(req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+	if needsRehash {
+		h.rehashPassword(c.UserContext(), user.Id, req.Password)
 	}
-	user, err := h.storage.GetUser(req.Email)
+	token, err := h.auth.Issue(c.UserContext(), auth.Principal{UserId: user.Id, Admin: user.IsAdmin})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
-	if user.Password != req.Password {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "wrong password"})
+	refreshToken, err := h.sessions.CreateRefreshToken(c.UserContext(), user.Id, user.IsAdmin)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token, "refresh_token": refreshToken})
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required,uuid"`
+}
+
+func (h *Handlers) RefreshToken(c *fiber.Ctx) error {
+	var req refreshRequest
+	if err := h.parseBody(c, &req); err != nil {
+		return err
 	}
-	token, err := h.cache.CreateSession(user.Id, user.IsAdmin)
+	userId, admin, refreshToken, err := h.sessions.RotateRefreshToken(c.UserContext(), req.RefreshToken)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token})
+	token, err := h.auth.Issue(c.UserContext(), auth.Principal{UserId: userId, Admin: admin})
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token, "refresh_token": refreshToken})
+}
+
+type logoutRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"omitempty,uuid"`
+}
+
+func (h *Handlers) Logout(c *fiber.Ctx) error {
+	var req logoutRequest
+	if len(c.Body()) > 0 {
+		if err := h.parseBody(c, &req); err != nil {
+			return err
+		}
+	}
+	if req.RefreshToken != "" {
+		err := h.sessions.DeleteRefreshToken(c.UserContext(), req.RefreshToken, auth.GetPrincipal(c).UserId)
+		if err != nil {
+			return err
+		}
+	}
+	if err := h.auth.Revoke(c.UserContext(), auth.GetToken(c)); err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ok"})
+}
+
+// DeleteSessions revokes every session and refresh token of the user.
+// Sessions from /dummyLogin have no user, so only the current one is revoked.
+func (h *Handlers) DeleteSessions(c *fiber.Ctx) error {
+	principal := auth.GetPrincipal(c)
+	if principal.UserId == "" {
+		if err := h.auth.Revoke(c.UserContext(), auth.GetToken(c)); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ok"})
+	}
+	if err := h.auth.RevokeAll(c.UserContext(), principal.UserId); err != nil {
+		return err
+	}
+	if err := h.sessions.DeleteUserRefreshTokens(c.UserContext(), principal.UserId); err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ok"})
 }
 
-func (h *Handlers) validateSession(id string) (userId string, admin bool, valid bool, err error) {
-	userId, admin, err = h.cache.GetSession(id)
-	if errors.Is(err, redis.Nil) {
-		return "", false, false, nil
+// rehashPassword replaces a legacy plaintext or outdated hash after a
+// successful login. Failures are only logged: the user is already
+// authenticated and the upgrade is retried on the next login.
+func (h *Handlers) rehashPassword(ctx context.Context, userId string, password string) {
+	hash, err := h.hasher.Hash(password)
+	if err == nil {
+		err = h.users.UpdateUserPassword(ctx, userId, hash)
 	}
 	if err != nil {
-		return "", false, false, err
+		log.Printf("failed to rehash password of user %s: %v", userId, err)
 	}
-	return userId, admin, true, nil
 }
 
 type createHomeRequest struct {
@@ -129,37 +227,185 @@ type createHomeRequest struct {
 }
 
 func (h *Handlers) CreateHome(c *fiber.Ctx) error {
-	sessionId := c.Get("auth")
-	userId, admin, valid, err := h.validateSession(sessionId)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
-	}
-	if !valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
-	}
-	if !admin {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you have no permission to create house"})
-	}
+	principal := auth.GetPrincipal(c)
 	var req createHomeRequest
-	err = c.BodyParser(&req)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
-	}
-	if err := h.validator.Struct(req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+	if err := h.parseBody(c, &req); err != nil {
+		return err
 	}
-	home, errCreation := h.storage.CreateHome(req.Address, req.Year, req.Developer, userId)
-	if errCreation != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+	home, err := h.houses.CreateHome(c.UserContext(), req.Address, req.Year, req.Developer, principal.UserId)
+	if err != nil {
+		return err
 	}
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"house": map[string]interface{}{
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"house": houseResponse(home)})
+}
+
+func houseResponse(home *entities.Home) map[string]interface{} {
+	response := map[string]interface{}{
 		"id":         home.Id,
 		"year":       home.Year,
 		"address":    home.Address,
 		"developer":  home.Developer,
 		"created_at": home.CreatedAt,
 		"updated_at": home.UpdatedAt,
-	}})
+	}
+	if home.DeletedAt != nil {
+		response["deleted_at"] = home.DeletedAt
+	}
+	return response
+}
+
+type updateHomeRequest struct {
+	Address   *string    `json:"address" validate:"omitempty,min=1,max=120"`
+	Year      *int       `json:"year" validate:"omitempty,min=1"`
+	Developer *string    `json:"developer" validate:"omitempty,max=30"`
+	UpdatedAt *time.Time `json:"updated_at" validate:"required"`
+}
+
+// UpdateHome changes the fields present in the body. updated_at must be the
+// value the client has read: if the house was changed since then, nothing is
+// updated and 409 is returned.
+func (h *Handlers) UpdateHome(c *fiber.Ctx) error {
+	houseId, err := paramInt(c, "id")
+	if err != nil {
+		return err
+	}
+	var req updateHomeRequest
+	if err := h.parseBody(c, &req); err != nil {
+		return err
+	}
+	home, err := h.houses.UpdateHome(c.UserContext(), houseId, req.Address, req.Year, req.Developer, *req.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"house": houseResponse(home)})
+}
+
+func (h *Handlers) DeleteHome(c *fiber.Ctx) error {
+	houseId, err := paramInt(c, "id")
+	if err != nil {
+		return err
+	}
+	home, err := h.houses.DeleteHome(c.UserContext(), houseId)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"house": houseResponse(home)})
+}
+
+func (h *Handlers) RestoreHome(c *fiber.Ctx) error {
+	houseId, err := paramInt(c, "id")
+	if err != nil {
+		return err
+	}
+	home, err := h.houses.RestoreHome(c.UserContext(), houseId)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"house": houseResponse(home)})
+}
+
+const (
+	defaultHousesLimit = 20
+	defaultFlatsLimit  = 20
+)
+
+type searchHousesRequest struct {
+	Address          string `json:"address" query:"address" validate:"max=120"`
+	Developer        string `json:"developer" query:"developer" validate:"max=30"`
+	YearFrom         int    `json:"year_from" query:"year_from" validate:"min=0"`
+	YearTo           int    `json:"year_to" query:"year_to" validate:"omitempty,gtefield=YearFrom"`
+	HasApprovedFlats bool   `json:"has_approved_flats" query:"has_approved_flats"`
+	Sort             string `json:"sort" query:"sort" validate:"omitempty,oneof=id -id address -address year -year created_at -created_at updated_at -updated_at"`
+	Cursor           string `json:"cursor" query:"cursor" validate:"max=512"`
+	Limit            int    `json:"limit" query:"limit" validate:"min=0,max=100"`
+}
+
+// SearchHouses lists houses page by page. The next page is requested with
+// next_cursor of the previous one and the same filters and sort.
+func (h *Handlers) SearchHouses(c *fiber.Ctx) error {
+	var req searchHousesRequest
+	if err := c.QueryParser(&req); err != nil {
+		return errBadRequest.WithMessage("query parameters are malformed").Wrap(err)
+	}
+	if err := h.validate(c, &req); err != nil {
+		return err
+	}
+	filter := entities.HomeFilter{
+		Address:          req.Address,
+		Developer:        req.Developer,
+		YearFrom:         req.YearFrom,
+		YearTo:           req.YearTo,
+		HasApprovedFlats: req.HasApprovedFlats,
+		IncludeDeleted:   seesDeletedHouses(auth.GetPrincipal(c)),
+		Sort:             strings.TrimPrefix(req.Sort, "-"),
+		Desc:             strings.HasPrefix(req.Sort, "-"),
+		Cursor:           req.Cursor,
+		Limit:            req.Limit,
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultHousesLimit
+	}
+	page, err := h.houses.SearchHomes(c.UserContext(), filter)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(page)
+}
+
+type searchFlatsRequest struct {
+	PriceMin  int      `json:"price_min" query:"price_min" validate:"min=0"`
+	PriceMax  int      `json:"price_max" query:"price_max" validate:"omitempty,gtefield=PriceMin"`
+	Rooms     []int    `json:"rooms" query:"rooms" validate:"max=10,dive,min=1"`
+	HouseIds  []int    `json:"house_id" query:"house_id" validate:"max=50,dive,min=1"`
+	Developer string   `json:"developer" query:"developer" validate:"max=30"`
+	YearFrom  int      `json:"year_from" query:"year_from" validate:"min=0"`
+	YearTo    int      `json:"year_to" query:"year_to" validate:"omitempty,gtefield=YearFrom"`
+	Statuses  []string `json:"status" query:"status" validate:"max=4,dive,oneof=created on_moderation approved declined"`
+	Sort      string   `json:"sort" query:"sort" validate:"omitempty,oneof=price -price created -created"`
+	Cursor    string   `json:"cursor" query:"cursor" validate:"max=512"`
+	Limit     int      `json:"limit" query:"limit" validate:"min=0,max=100"`
+}
+
+// SearchFlats lists flats of all houses page by page. Visibility is the same
+// as in GET /house/{id}: clients get only approved flats whatever status they
+// ask for, moderators may filter by any status.
+func (h *Handlers) SearchFlats(c *fiber.Ctx) error {
+	principal := auth.GetPrincipal(c)
+	var req searchFlatsRequest
+	if err := c.QueryParser(&req); err != nil {
+		return errBadRequest.WithMessage("query parameters are malformed").Wrap(err)
+	}
+	if err := h.validate(c, &req); err != nil {
+		return err
+	}
+	statuses := req.Statuses
+	if !seesAllStatuses(principal) {
+		statuses = []string{string(entities.APPROVED)}
+	}
+	filter := entities.FlatFilter{
+		PriceMin:       req.PriceMin,
+		PriceMax:       req.PriceMax,
+		Rooms:          req.Rooms,
+		HouseIds:       req.HouseIds,
+		Developer:      req.Developer,
+		YearFrom:       req.YearFrom,
+		YearTo:         req.YearTo,
+		Statuses:       statuses,
+		MarketStatuses: []string{string(entities.ACTIVE)},
+		IncludeDeleted: seesDeletedHouses(principal),
+		Sort:           strings.TrimPrefix(req.Sort, "-"),
+		Desc:           strings.HasPrefix(req.Sort, "-"),
+		Cursor:         req.Cursor,
+		Limit:          req.Limit,
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultFlatsLimit
+	}
+	page, err := h.flats.SearchFlats(c.UserContext(), filter)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(page)
 }
 
 type createFlatRequest struct {
@@ -170,43 +416,67 @@ type createFlatRequest struct {
 }
 
 func (h *Handlers) CreateFlat(c *fiber.Ctx) error {
-	sessionId := c.Get("auth")
-	_, _, valid, err := h.validateSession(sessionId)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
-	}
-	if !valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
-	}
 	var req createFlatRequest
-	err = c.BodyParser(&req)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
-	}
-	if err := h.validator.Struct(req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+	if err := h.parseBody(c, &req); err != nil {
+		return err
 	}
-	if _, err := h.storage.GetLastHomeUpdate(req.HouseId); err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "house with specified id not found"})
-	}
-	flat, err := h.storage.CreateFlat(
+	flat, err := h.flats.CreateFlat(
+		c.UserContext(),
 		req.FlatId,
 		req.HouseId,
 		req.Price,
 		req.Rooms,
+		auth.GetPrincipal(c).UserId,
 	)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flat": flat})
 }
 
+type importedFlatRequest struct {
+	FlatId int `json:"id" validate:"required,min=1"`
+	Price  int `json:"price" validate:"required,min=1"`
+	Rooms  int `json:"rooms" validate:"required,min=1"`
+}
+
+type importFlatsRequest struct {
+	Flats []importedFlatRequest `json:"flats" validate:"required,min=1,max=1000,unique=FlatId,dive"`
+}
+
+// ImportFlats creates many flats of the house at once, all or none of them.
+func (h *Handlers) ImportFlats(c *fiber.Ctx) error {
+	houseId, err := paramInt(c, "id")
+	if err != nil {
+		return err
+	}
+	var req importFlatsRequest
+	if err := h.parseBody(c, &req); err != nil {
+		return err
+	}
+	flats := make([]entities.Flat, len(req.Flats))
+	for i, flat := range req.Flats {
+		flats[i] = entities.Flat{Number: flat.FlatId, Price: flat.Price, Rooms: flat.Rooms}
+	}
+	imported, err := h.flats.ImportFlats(c.UserContext(), houseId, flats, auth.GetPrincipal(c).UserId)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flats": imported})
+}
+
+type declineReasonRequest struct {
+	Code string `json:"code" validate:"required,oneof=wrong_price wrong_rooms duplicate prohibited_content other"`
+	Text string `json:"text" validate:"max=500"`
+}
+
 type updateFlatRequest struct {
-	HouseId int    `json:"house_id" validate:"required,min=1"`
-	FlatId  int    `json:"id" validate:"required,min=1"`
-	Price   int    `json:"price" validate:"required,min=1"`
-	Rooms   int    `json:"rooms" validate:"required,min=1"`
-	Status  string `json:"status" validate:"required,min=1"`
+	HouseId       int                   `json:"house_id" validate:"required,min=1"`
+	FlatId        int                   `json:"id" validate:"required,min=1"`
+	Price         int                   `json:"price" validate:"required,min=1"`
+	Rooms         int                   `json:"rooms" validate:"required,min=1"`
+	Status        string                `json:"status" validate:"required,min=1"`
+	DeclineReason *declineReasonRequest `json:"decline_reason" validate:"required_if=Status declined"`
 }
 
 func (h *Handlers) setStatus(s string) (entities.ModerationStatus, error) {
@@ -220,100 +490,343 @@ func (h *Handlers) setStatus(s string) (entities.ModerationStatus, error) {
 	case "on_moderation":
 		return entities.ON_MODERATION, nil
 	default:
-		return entities.CREATED, errors.New("invalid moderation status")
+		return entities.CREATED, errInvalidStatus
 	}
 }
 
 func (h *Handlers) UpdateFlat(c *fiber.Ctx) error {
-	sessionId := c.Get("auth")
-	userId, admin, valid, err := h.validateSession(sessionId)
+	principal := auth.GetPrincipal(c)
+	var req updateFlatRequest
+	if err := h.parseBody(c, &req); err != nil {
+		return err
+	}
+	status, err := h.setStatus(req.Status)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
-	if !valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	var declineReason *entities.DeclineReason
+	if status == entities.DECLINED {
+		declineReason = &entities.DeclineReason{Code: req.DeclineReason.Code, Text: req.DeclineReason.Text}
 	}
-	if !admin {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you have no permission to update this house"})
+	flat, err := h.flats.UpdateFlat(
+		c.UserContext(),
+		req.FlatId,
+		req.HouseId,
+		req.Price,
+		req.Rooms,
+		status,
+		declineReason,
+		principal.UserId,
+	)
+	if err != nil {
+		return err
 	}
-	var req updateFlatRequest
-	err = c.BodyParser(&req)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flat": flat})
+}
+
+type claimFlatRequest struct {
+	HouseId int `json:"house_id" validate:"required,min=1"`
+	FlatId  int `json:"id" validate:"required,min=1"`
+}
+
+func (h *Handlers) ClaimFlat(c *fiber.Ctx) error {
+	principal := auth.GetPrincipal(c)
+	var req claimFlatRequest
+	if err := h.parseBody(c, &req); err != nil {
+		return err
+	}
+	flat, err := h.flats.ClaimFlat(c.UserContext(), req.FlatId, req.HouseId, principal.UserId)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+		return err
 	}
-	if err := h.validator.Struct(req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flat": flat})
+}
+
+type resubmitFlatRequest struct {
+	HouseId int `json:"house_id" validate:"required,min=1"`
+	FlatId  int `json:"id" validate:"required,min=1"`
+	Price   int `json:"price" validate:"required,min=1"`
+	Rooms   int `json:"rooms" validate:"required,min=1"`
+}
+
+func (h *Handlers) ResubmitFlat(c *fiber.Ctx) error {
+	principal := auth.GetPrincipal(c)
+	var req resubmitFlatRequest
+	if err := h.parseBody(c, &req); err != nil {
+		return err
 	}
-	status, err := h.setStatus(req.Status)
+	flat, err := h.flats.ResubmitFlat(c.UserContext(), req.FlatId, req.HouseId, req.Price, req.Rooms, principal.UserId, principal.Admin)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flat": flat})
+}
+
+// GetFlat shows a flat in any status, including its decline reason, to its
+// creator and to moderators. Other clients see only approved flats that are
+// still on the market.
+func (h *Handlers) GetFlat(c *fiber.Ctx) error {
+	principal := auth.GetPrincipal(c)
+	houseId, err := paramInt(c, "house_id")
+	if err != nil {
+		return err
+	}
+	flatId, err := paramInt(c, "id")
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+		return err
 	}
-	reviewer, err := h.storage.GetHomeReviewer(req.HouseId)
-	if errors.Is(err, sql.ErrNoRows) {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "house with specified id not found"})
+	if !seesDeletedHouses(principal) {
+		if _, err := h.houses.GetLastHomeUpdate(c.UserContext(), houseId); err != nil {
+			return err
+		}
 	}
+	flat, err := h.flats.GetFlat(c.UserContext(), flatId, houseId)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
-	if reviewer != userId {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "only house creator able to review flats placed in this house"})
+	owner := flat.CreatedBy != "" && flat.CreatedBy == principal.UserId
+	visible := flat.Status == string(entities.APPROVED) && flat.MarketStatus == string(entities.ACTIVE)
+	if !seesAllStatuses(principal) && !owner && !visible {
+		return storages.ErrFlatNotFound
 	}
-	flat, err := h.storage.UpdateFlat(
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flat": flat})
+}
+
+type marketStatusRequest struct {
+	HouseId      int    `json:"house_id" validate:"required,min=1"`
+	FlatId       int    `json:"id" validate:"required,min=1"`
+	MarketStatus string `json:"market_status" validate:"required,oneof=sold rented withdrawn"`
+}
+
+// SetMarketStatus takes the flat off the market. It is allowed to the creator
+// of the flat and to moderators.
+func (h *Handlers) SetMarketStatus(c *fiber.Ctx) error {
+	principal := auth.GetPrincipal(c)
+	var req marketStatusRequest
+	if err := h.parseBody(c, &req); err != nil {
+		return err
+	}
+	flat, err := h.flats.SetMarketStatus(
+		c.UserContext(),
 		req.FlatId,
 		req.HouseId,
-		req.Price,
-		req.Rooms,
-		status,
+		entities.MarketStatus(req.MarketStatus),
+		principal.UserId,
+		principal.Admin,
 	)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flat": flat})
 }
 
-func (h *Handlers) getHouseFlats(houseId int, admin bool) ([]entities.Flat, error) {
-	if admin {
-		return h.storage.FilterFlats(houseId, true)
+type flatArchiveRequest struct {
+	HouseIds       []int    `json:"house_id" query:"house_id" validate:"max=50,dive,min=1"`
+	MarketStatuses []string `json:"market_status" query:"market_status" validate:"max=3,dive,oneof=sold rented withdrawn"`
+	Sort           string   `json:"sort" query:"sort" validate:"omitempty,oneof=price -price created -created"`
+	Cursor         string   `json:"cursor" query:"cursor" validate:"max=512"`
+	Limit          int      `json:"limit" query:"limit" validate:"min=0,max=100"`
+}
+
+// GetFlatArchive lists sold, rented and withdrawn flats, including flats of
+// deleted houses.
+func (h *Handlers) GetFlatArchive(c *fiber.Ctx) error {
+	var req flatArchiveRequest
+	if err := c.QueryParser(&req); err != nil {
+		return errBadRequest.WithMessage("query parameters are malformed").Wrap(err)
+	}
+	if err := h.validate(c, &req); err != nil {
+		return err
+	}
+	filter := entities.FlatFilter{
+		HouseIds:       req.HouseIds,
+		MarketStatuses: req.MarketStatuses,
+		IncludeDeleted: true,
+		Sort:           strings.TrimPrefix(req.Sort, "-"),
+		Desc:           strings.HasPrefix(req.Sort, "-"),
+		Cursor:         req.Cursor,
+		Limit:          req.Limit,
+	}
+	if len(filter.MarketStatuses) == 0 {
+		filter.MarketStatuses = entities.ARCHIVED
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultFlatsLimit
+	}
+	page, err := h.flats.SearchFlats(c.UserContext(), filter)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(page)
+}
+
+// GetMyFlats lists flats created by the user in every house and status.
+func (h *Handlers) GetMyFlats(c *fiber.Ctx) error {
+	principal := auth.GetPrincipal(c)
+	if principal.UserId == "" {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"flats": []entities.Flat{}})
 	}
-	lastUpdate, err := h.storage.GetLastHomeUpdate(houseId)
+	flats, err := h.flats.GetUserFlats(c.UserContext(), principal.UserId)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flats": flats})
+}
+
+// seesAllStatuses tells whether flats in every status are visible to the
+// principal. Clients see only approved flats of other users.
+func seesAllStatuses(principal *auth.Principal) bool {
+	return principal.Admin
+}
+
+type houseFlatsRequest struct {
+	PriceMin int    `json:"price_min" query:"price_min" validate:"min=0"`
+	PriceMax int    `json:"price_max" query:"price_max" validate:"omitempty,gtefield=PriceMin"`
+	Rooms    []int  `json:"rooms" query:"rooms" validate:"max=10,dive,min=1"`
+	Sort     string `json:"sort" query:"sort" validate:"omitempty,oneof=price -price rooms -rooms number -number"`
+	Cursor   string `json:"cursor" query:"cursor" validate:"max=512"`
+	Limit    int    `json:"limit" query:"limit" validate:"min=0,max=100"`
+}
+
+// houseFlatsCacheKey names a cached page of flats. The key changes with every
+// update of the house and includes every parameter affecting the page.
+func houseFlatsCacheKey(houseId int, lastUpdate time.Time, filter entities.FlatFilter) string {
+	rooms := make([]string, 0, len(filter.Rooms))
+	for _, r := range filter.Rooms {
+		rooms = append(rooms, strconv.Itoa(r))
+	}
+	sort.Strings(rooms)
+	return strings.Join([]string{
+		strconv.FormatInt(lastUpdate.UnixNano(), 10),
+		strconv.Itoa(houseId),
+		strconv.Itoa(filter.PriceMin),
+		strconv.Itoa(filter.PriceMax),
+		strings.Join(rooms, ","),
+		filter.Sort,
+		strconv.FormatBool(filter.Desc),
+		strconv.Itoa(filter.Limit),
+		filter.Cursor,
+	}, "-")
+}
+
+// seesDeletedHouses tells whether soft-deleted houses and their flats are
+// visible to the principal.
+func seesDeletedHouses(principal *auth.Principal) bool {
+	return principal.Admin
+}
+
+func (h *Handlers) getHouseFlats(
+	ctx context.Context,
+	houseId int,
+	principal *auth.Principal,
+	filter entities.FlatFilter) (*entities.FlatPage, error) {
+	if seesAllStatuses(principal) {
+		return h.flats.FilterFlats(ctx, houseId, true, filter)
+	}
+	lastUpdate, err := h.houses.GetLastHomeUpdate(ctx, houseId)
 	if err != nil {
 		return nil, err
 	}
-	cacheName := strconv.FormatInt(lastUpdate.Unix(), 10) + "-" + strconv.Itoa(houseId)
-	var flats []entities.Flat
-	flats, err = h.cache.GetFlatsCache(cacheName)
-	if err != nil && !errors.Is(err, redis.Nil) {
+	cacheName := houseFlatsCacheKey(houseId, lastUpdate, filter)
+	page, err := h.flatsCache.GetFlatsCache(ctx, cacheName)
+	if err != nil && !errors.Is(err, repositories.ErrNotCached) {
 		return nil, err
-	} else if errors.Is(err, redis.Nil) {
-		r, err2 := h.storage.FilterFlats(houseId, false)
+	} else if errors.Is(err, repositories.ErrNotCached) {
+		r, err2 := h.flats.FilterFlats(ctx, houseId, false, filter)
 		if err2 != nil {
 			return nil, err2
 		}
-		if err3 := h.cache.PutFlatsCache(cacheName, r); err3 != nil {
+		if err3 := h.flatsCache.PutFlatsCache(ctx, cacheName, r); err3 != nil {
 			return nil, err3
 		}
 		return r, nil
 	}
-	return flats, nil
+	return page, nil
 }
 
 func (h *Handlers) GetHouseFlats(c *fiber.Ctx) error {
-	sessionId := c.Get("auth")
-	_, admin, valid, err := h.validateSession(sessionId)
+	principal := auth.GetPrincipal(c)
+	houseId, err := paramInt(c, "id")
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
+	}
+	var req houseFlatsRequest
+	if err := c.QueryParser(&req); err != nil {
+		return errBadRequest.WithMessage("query parameters are malformed").Wrap(err)
+	}
+	if err := h.validate(c, &req); err != nil {
+		return err
 	}
-	if !valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	filter := entities.FlatFilter{
+		PriceMin: req.PriceMin,
+		PriceMax: req.PriceMax,
+		Rooms:    req.Rooms,
+		Sort:     strings.TrimPrefix(req.Sort, "-"),
+		Desc:     strings.HasPrefix(req.Sort, "-"),
+		Cursor:   req.Cursor,
+		Limit:    req.Limit,
 	}
-	houseIdStr := c.Params("id")
-	houseId, err := strconv.Atoi(houseIdStr)
+	if filter.Sort == "" {
+		filter.Sort = "number"
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultFlatsLimit
+	}
+	page, err := h.getHouseFlats(c.UserContext(), houseId, principal, filter)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(page)
+}
+
+// StartModeration moves all created flats of the house to on_moderation.
+func (h *Handlers) StartModeration(c *fiber.Ctx) error {
+	principal := auth.GetPrincipal(c)
+	houseId, err := paramInt(c, "id")
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bad request"})
+		return err
 	}
-	flats, err := h.getHouseFlats(houseId, admin)
+	flats, err := h.flats.StartModeration(c.UserContext(), houseId, principal.UserId)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+		return err
 	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"flats": flats})
 }
+
+type subscribeRequest struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+}
+
+func (h *Handlers) Subscribe(c *fiber.Ctx) error {
+	houseId, err := paramInt(c, "id")
+	if err != nil {
+		return err
+	}
+	var req subscribeRequest
+	if err := h.parseBody(c, &req); err != nil {
+		return err
+	}
+	if _, err := h.houses.GetLastHomeUpdate(c.UserContext(), houseId); err != nil {
+		return err
+	}
+	if err := h.houses.CreateSubscription(c.UserContext(), houseId, req.Email); err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"house_id": houseId, "email": req.Email})
+}
+
+func (h *Handlers) GetFlatHistory(c *fiber.Ctx) error {
+	houseId, err := paramInt(c, "house_id")
+	if err != nil {
+		return err
+	}
+	flatId, err := paramInt(c, "id")
+	if err != nil {
+		return err
+	}
+	history, err := h.flats.GetFlatHistory(c.UserContext(), houseId, flatId)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"history": history})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bootcamp_task/storage/errs"
+	"errors"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/ru"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	enTranslations "github.com/go-playground/validator/v10/translations/en"
+	ruTranslations "github.com/go-playground/validator/v10/translations/ru"
+	"github.com/gofiber/fiber/v2"
+	"reflect"
+	"strings"
+)
+
+// defaultLanguage is used when Accept-Language names none of the supported
+// languages.
+const defaultLanguage = "en"
+
+var validationFailedMessages = map[string]string{
+	"en": "request validation failed",
+	"ru": "ошибка валидации запроса",
+}
+
+// newValidator creates a validator that reports fields by their json names
+// and a translator with messages for every supported language.
+func newValidator() (*validator.Validate, *ut.UniversalTranslator) {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	enLocale := en.New()
+	translator := ut.New(enLocale, enLocale, ru.New())
+	enTrans, _ := translator.GetTranslator("en")
+	if err := enTranslations.RegisterDefaultTranslations(v, enTrans); err != nil {
+		panic(err)
+	}
+	ruTrans, _ := translator.GetTranslator("ru")
+	if err := ruTranslations.RegisterDefaultTranslations(v, ruTrans); err != nil {
+		panic(err)
+	}
+	return v, translator
+}
+
+// language picks the best supported language from Accept-Language.
+func language(c *fiber.Ctx) string {
+	if lang := c.AcceptsLanguages("en", "ru"); lang != "" {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// validate checks req and lists every violated rule with a message in the
+// language of the request.
+func (h *Handlers) validate(c *fiber.Ctx, req interface{}) error {
+	err := h.validator.Struct(req)
+	if err == nil {
+		return nil
+	}
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return errBadRequest.Wrap(err)
+	}
+	lang := language(c)
+	trans, _ := h.translator.GetTranslator(lang)
+	fields := make([]errs.FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		fields = append(fields, errs.FieldError{
+			Field:   fieldPath(fe),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fe.Translate(trans),
+		})
+	}
+	return errs.Validation("validation_failed", validationFailedMessages[lang], fields...)
+}
+
+// fieldPath returns the json path of the field without the request struct
+// name, e.g. decline_reason.code.
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.IndexByte(namespace, '.'); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
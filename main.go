@@ -2,9 +2,16 @@ package main
 
 import (
 	"bootcamp_task/server"
+	"flag"
 	_ "github.com/gofiber/swagger"
 )
 
 func main() {
+	memory := flag.Bool("memory", false, "keep all data in memory instead of Postgres and Redis")
+	flag.Parse()
+	if *memory {
+		server.BuildServer(server.InMemoryRepositories).Run()
+		return
+	}
 	server.BuildServerAndEnv().Run()
 }
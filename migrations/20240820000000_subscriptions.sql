@@ -0,0 +1,16 @@
+-- +goose Up
+
+-- +goose StatementBegin
+CREATE TABLE subscriptions (
+    home_id INT REFERENCES homes(id) NOT NULL,
+    email VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (home_id, email)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+DROP TABLE subscriptions;
+-- +goose StatementEnd
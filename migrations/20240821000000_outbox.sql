@@ -0,0 +1,23 @@
+-- +goose Up
+
+-- +goose StatementBegin
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    delivered_at TIMESTAMP,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX outbox_undelivered_idx ON outbox (id) WHERE delivered_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+DROP TABLE outbox;
+-- +goose StatementEnd
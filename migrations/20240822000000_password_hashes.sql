@@ -0,0 +1,10 @@
+-- +goose Up
+
+-- +goose StatementBegin
+ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(255);
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- Stored hashes do not fit into the former VARCHAR(50) and cannot be turned
+-- back into passwords, so the column keeps its size.
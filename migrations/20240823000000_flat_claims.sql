@@ -0,0 +1,19 @@
+-- +goose Up
+
+-- +goose StatementBegin
+ALTER TABLE flats ADD COLUMN moderator_id VARCHAR(36);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats ADD COLUMN claimed_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+ALTER TABLE flats DROP COLUMN claimed_at;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats DROP COLUMN moderator_id;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+
+-- +goose StatementBegin
+CREATE TABLE flat_moderation_events (
+    id BIGSERIAL PRIMARY KEY,
+    home_id INT REFERENCES homes(id) NOT NULL,
+    flat_number INT NOT NULL,
+    actor_id VARCHAR(36) NOT NULL,
+    previous_status FLAT_STATUS NOT NULL,
+    new_status FLAT_STATUS NOT NULL,
+    previous_price INT NOT NULL,
+    new_price INT NOT NULL,
+    previous_rooms INT NOT NULL,
+    new_rooms INT NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX flat_moderation_events_flat_idx ON flat_moderation_events (home_id, flat_number, id);
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+DROP TABLE flat_moderation_events;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+
+-- +goose StatementBegin
+ALTER TABLE flats ADD COLUMN decline_code VARCHAR(30);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats ADD COLUMN decline_text VARCHAR(500);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats ADD COLUMN resubmissions INT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+ALTER TABLE flats DROP COLUMN resubmissions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats DROP COLUMN decline_text;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats DROP COLUMN decline_code;
+-- +goose StatementEnd
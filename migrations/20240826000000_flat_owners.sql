@@ -0,0 +1,19 @@
+-- +goose Up
+
+-- +goose StatementBegin
+ALTER TABLE flats ADD COLUMN created_by VARCHAR(36);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX flats_created_by_idx ON flats (created_by);
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+DROP INDEX flats_created_by_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats DROP COLUMN created_by;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+
+-- +goose StatementBegin
+ALTER TABLE flats ADD COLUMN id BIGSERIAL PRIMARY KEY;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DELETE FROM flats duplicate USING flats original
+WHERE duplicate.home_id = original.home_id
+  AND duplicate.number = original.number
+  AND duplicate.id > original.id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats ADD CONSTRAINT flats_home_id_number_key UNIQUE (home_id, number);
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+ALTER TABLE flats DROP CONSTRAINT flats_home_id_number_key;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats DROP COLUMN id;
+-- +goose StatementEnd
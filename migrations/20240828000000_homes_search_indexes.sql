@@ -0,0 +1,63 @@
+-- +goose Up
+
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_address_trgm_idx ON homes USING gin (address gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_developer_idx ON homes (developer, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_year_idx ON homes (year, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_address_idx ON homes (address, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_created_at_idx ON homes (created_at, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_updated_at_idx ON homes (updated_at, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX flats_approved_home_idx ON flats (home_id) WHERE status = 'approved';
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+DROP INDEX flats_approved_home_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_updated_at_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_created_at_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_address_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_year_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_developer_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_address_trgm_idx;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+
+-- +goose StatementBegin
+CREATE INDEX flats_status_price_idx ON flats (status, price, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX flats_status_id_idx ON flats (status, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX flats_home_status_price_idx ON flats (home_id, status, price, id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_developer_year_idx ON homes (developer, year);
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+DROP INDEX homes_developer_year_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX flats_home_status_price_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX flats_status_id_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX flats_status_price_idx;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+-- +goose Up
+
+-- +goose StatementBegin
+ALTER TABLE homes ADD COLUMN deleted_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- Clients never see deleted houses, so their searches sort live houses only.
+
+-- +goose StatementBegin
+CREATE INDEX homes_live_id_idx ON homes (id) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_live_address_idx ON homes (address, id) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_live_year_idx ON homes (year, id) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_live_created_at_idx ON homes (created_at, id) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX homes_live_updated_at_idx ON homes (updated_at, id) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+DROP INDEX homes_live_updated_at_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_live_created_at_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_live_year_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_live_address_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX homes_live_id_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE homes DROP COLUMN deleted_at;
+-- +goose StatementEnd
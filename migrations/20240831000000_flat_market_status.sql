@@ -0,0 +1,31 @@
+-- +goose Up
+
+-- +goose StatementBegin
+CREATE TYPE FLAT_MARKET_STATUS AS ENUM ('active', 'sold', 'rented', 'withdrawn');
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats
+    ADD COLUMN market_status FLAT_MARKET_STATUS NOT NULL DEFAULT 'active',
+    ADD COLUMN market_changed_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX flats_archive_idx ON flats (home_id, id) WHERE market_status <> 'active';
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+DROP INDEX flats_archive_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE flats
+    DROP COLUMN market_changed_at,
+    DROP COLUMN market_status;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TYPE FLAT_MARKET_STATUS;
+-- +goose StatementEnd
@@ -0,0 +1,52 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSender is a local stand-in for SmtpSender: every email is appended
+// to a file as a JSON line, so tests and local runs can inspect them.
+type FileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+type fileEmail struct {
+	Recipient string    `json:"recipient"`
+	Message   string    `json:"message"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+func NewFileSender(path string) *FileSender {
+	if path == "" {
+		path = "emails.log"
+	}
+	return &FileSender{path: path}
+}
+
+func (f *FileSender) SendEmail(ctx context.Context, recipient string, message string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(fileEmail{
+		Recipient: recipient,
+		Message:   message,
+		SentAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,51 @@
+package notifications
+
+import (
+	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/repositories"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// FlatApprovedConsumer emails house subscribers when a flat of the house
+// becomes approved.
+type FlatApprovedConsumer struct {
+	storage  repositories.HouseRepository
+	notifier *Notifier
+}
+
+func NewFlatApprovedConsumer(storage repositories.HouseRepository, notifier *Notifier) *FlatApprovedConsumer {
+	return &FlatApprovedConsumer{
+		storage:  storage,
+		notifier: notifier,
+	}
+}
+
+func (f *FlatApprovedConsumer) Name() string {
+	return "flat_approved_notifications"
+}
+
+func (f *FlatApprovedConsumer) Consume(ctx context.Context, event entities.OutboxEvent) error {
+	if event.Type != entities.FLAT_STATUS_CHANGED {
+		return nil
+	}
+	var payload entities.FlatEvent
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return err
+	}
+	if payload.Flat.Status != string(entities.APPROVED) {
+		return nil
+	}
+	subscribers, err := f.storage.GetSubscribers(ctx, payload.Flat.HomeId)
+	if err != nil {
+		return err
+	}
+	return f.notifier.Notify(ctx, subscribers, fmt.Sprintf(
+		"New flat %d is available in house %d: %d rooms, price %d",
+		payload.Flat.Number,
+		payload.Flat.HomeId,
+		payload.Flat.Rooms,
+		payload.Flat.Price,
+	))
+}
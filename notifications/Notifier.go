@@ -0,0 +1,154 @@
+package notifications
+
+import (
+	"bootcamp_task/config"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+var ErrNotifierStopped = errors.New("notifier is stopped")
+
+type email struct {
+	recipient string
+	message   string
+	attempt   int
+}
+
+// Notifier delivers emails in background workers, so a slow mail server
+// never blocks the request that triggered the notification. Failed
+// deliveries are retried with exponential backoff.
+type Notifier struct {
+	sender      Sender
+	queue       chan email
+	workers     int
+	maxRetries  int
+	retryDelay  time.Duration
+	sendTimeout time.Duration
+	ctx         context.Context
+	cancel      func()
+	wg          sync.WaitGroup
+}
+
+func NewNotifier(lc fx.Lifecycle, cfg *config.Config, sender Sender) *Notifier {
+	n := &Notifier{}
+	n.Init(
+		sender,
+		cfg.Notifications.Workers,
+		cfg.Notifications.QueueSize,
+		cfg.Notifications.MaxRetries,
+		cfg.Notifications.RetryDelay,
+		cfg.Notifications.SendTimeout,
+	)
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			n.Start()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			n.Stop()
+			return nil
+		},
+	})
+	return n
+}
+
+func (n *Notifier) Init(
+	sender Sender,
+	workers int,
+	queueSize int,
+	maxRetries int,
+	retryDelay int,
+	sendTimeout int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = 100
+	}
+	n.sender = sender
+	n.queue = make(chan email, queueSize)
+	n.workers = workers
+	n.maxRetries = maxRetries
+	n.retryDelay = time.Duration(retryDelay) * time.Millisecond
+	n.sendTimeout = time.Duration(sendTimeout) * time.Millisecond
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+}
+
+func (n *Notifier) Start() {
+	for i := 0; i < n.workers; i++ {
+		n.wg.Add(1)
+		go n.work()
+	}
+}
+
+func (n *Notifier) Stop() {
+	n.cancel()
+	n.wg.Wait()
+}
+
+// Notify queues message for every recipient. While the queue is full it
+// waits for room until ctx is done and then returns an error, so the caller
+// can retry later. Recipients queued before the error get the message again
+// on a retry.
+func (n *Notifier) Notify(ctx context.Context, recipients []string, message string) error {
+	for i, recipient := range recipients {
+		select {
+		case n.queue <- email{recipient: recipient, message: message}:
+		case <-ctx.Done():
+			return fmt.Errorf("notification queue is full, %d of %d emails queued: %w", i, len(recipients), ctx.Err())
+		case <-n.ctx.Done():
+			return ErrNotifierStopped
+		}
+	}
+	return nil
+}
+
+// requeue puts a failed email back into the queue, waiting for room until
+// the notifier is stopped.
+func (n *Notifier) requeue(e email) {
+	select {
+	case <-n.ctx.Done():
+		log.Printf("notifier stopped, dropping email to %s", e.recipient)
+	case n.queue <- e:
+	}
+}
+
+func (n *Notifier) work() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case e := <-n.queue:
+			n.send(e)
+		}
+	}
+}
+
+func (n *Notifier) send(e email) {
+	ctx := n.ctx
+	if n.sendTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(n.ctx, n.sendTimeout)
+		defer cancel()
+	}
+	err := n.sender.SendEmail(ctx, e.recipient, e.message)
+	if err == nil {
+		return
+	}
+	if e.attempt >= n.maxRetries {
+		log.Printf("failed to send email to %s after %d attempts: %v", e.recipient, e.attempt+1, err)
+		return
+	}
+	delay := n.retryDelay << e.attempt
+	e.attempt++
+	time.AfterFunc(delay, func() {
+		n.requeue(e)
+	})
+}
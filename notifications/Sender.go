@@ -0,0 +1,28 @@
+package notifications
+
+import (
+	"bootcamp_task/config"
+	"context"
+	"errors"
+)
+
+type Sender interface {
+	SendEmail(ctx context.Context, recipient string, message string) error
+}
+
+func NewSender(cfg *config.Config) Sender {
+	switch cfg.Notifications.Sender {
+	case "smtp":
+		return NewSmtpSender(
+			cfg.Notifications.Smtp.Host,
+			cfg.Notifications.Smtp.Port,
+			cfg.Notifications.Smtp.User,
+			cfg.Notifications.Smtp.Password,
+			cfg.Notifications.Smtp.From,
+		)
+	case "file", "":
+		return NewFileSender(cfg.Notifications.FilePath)
+	default:
+		panic(errors.New("unknown notification sender: " + cfg.Notifications.Sender))
+	}
+}
@@ -0,0 +1,82 @@
+package notifications
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+type SmtpSender struct {
+	host     string
+	port     int
+	user     string
+	password string
+	from     string
+}
+
+func NewSmtpSender(host string, port int, user string, password string, from string) *SmtpSender {
+	return &SmtpSender{
+		host:     host,
+		port:     port,
+		user:     user,
+		password: password,
+		from:     from,
+	}
+}
+
+func (s *SmtpSender) SendEmail(ctx context.Context, recipient string, message string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.host, strconv.Itoa(s.port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.user != "" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(nil); err != nil {
+				return err
+			}
+		}
+		if err := client.Auth(smtp.PlainAuth("", s.user, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	body := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + recipient,
+		"Subject: New flat available",
+		"",
+		message,
+	}, "\r\n")
+	if _, err := w.Write([]byte(body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
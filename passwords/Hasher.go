@@ -0,0 +1,186 @@
+package passwords
+
+import (
+	"bootcamp_task/config"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ARGON2ID = "argon2id"
+	BCRYPT   = "bcrypt"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Hasher hashes passwords with the configured algorithm. Hashes are stored
+// in a self-describing format (PHC string for argon2id, modular crypt for
+// bcrypt), so parameters may change without invalidating older hashes.
+type Hasher struct {
+	algorithm     string
+	bcryptCost    int
+	argon2Memory  uint32
+	argon2Time    uint32
+	argon2Threads uint8
+}
+
+func NewHasher(cfg *config.Config) *Hasher {
+	h := Hasher{}
+	err := h.Init(
+		cfg.Passwords.Algorithm,
+		cfg.Passwords.BcryptCost,
+		cfg.Passwords.Argon2Memory,
+		cfg.Passwords.Argon2Time,
+		cfg.Passwords.Argon2Threads,
+	)
+	if err != nil {
+		panic(err)
+	}
+	return &h
+}
+
+func (h *Hasher) Init(
+	algorithm string,
+	bcryptCost int,
+	argon2Memory int,
+	argon2Time int,
+	argon2Threads int) error {
+	switch algorithm {
+	case "":
+		algorithm = ARGON2ID
+	case ARGON2ID, BCRYPT:
+	default:
+		return errors.New("unknown password hashing algorithm: " + algorithm)
+	}
+	if bcryptCost == 0 {
+		bcryptCost = bcrypt.DefaultCost
+	}
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if argon2Memory <= 0 {
+		argon2Memory = 64 * 1024
+	}
+	if argon2Time <= 0 {
+		argon2Time = 1
+	}
+	if argon2Threads <= 0 || argon2Threads > 255 {
+		argon2Threads = 4
+	}
+	h.algorithm = algorithm
+	h.bcryptCost = bcryptCost
+	h.argon2Memory = uint32(argon2Memory)
+	h.argon2Time = uint32(argon2Time)
+	h.argon2Threads = uint8(argon2Threads)
+	return nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.algorithm == BCRYPT {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.argon2Time, h.argon2Memory, h.argon2Threads, argon2KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.argon2Memory,
+		h.argon2Time,
+		h.argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against stored in constant time. Stored values
+// that are not recognized as hashes are treated as legacy plaintext
+// passwords. needsRehash reports that the password matched, but stored
+// was produced by another algorithm or with other parameters than the
+// configured ones and should be replaced with a fresh Hash.
+func (h *Hasher) Verify(stored string, password string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return h.verifyArgon2(stored, password)
+	case isBcrypt(stored):
+		return h.verifyBcrypt(stored, password)
+	default:
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok, nil
+	}
+}
+
+func isBcrypt(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") ||
+		strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$")
+}
+
+func (h *Hasher) verifyBcrypt(stored string, password string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	if err != nil {
+		return false, false, err
+	}
+	return true, h.algorithm != BCRYPT || cost != h.bcryptCost, nil
+}
+
+func (h *Hasher) verifyArgon2(stored string, password string) (bool, bool, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, false, ErrInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return false, false, ErrInvalidHash
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrInvalidHash
+	}
+	actual := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, actual) != 1 {
+		return false, false, nil
+	}
+	needsRehash := h.algorithm != ARGON2ID ||
+		memory != h.argon2Memory ||
+		time != h.argon2Time ||
+		threads != h.argon2Threads
+	return true, needsRehash, nil
+}
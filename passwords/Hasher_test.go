@@ -0,0 +1,97 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestHasher returns a hasher with cheap parameters, so tests stay fast.
+func newTestHasher(t *testing.T, algorithm string, bcryptCost int, argon2Memory int) *Hasher {
+	t.Helper()
+	h := &Hasher{}
+	if err := h.Init(algorithm, bcryptCost, argon2Memory, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHasher(t *testing.T) {
+	argon2Hasher := newTestHasher(t, ARGON2ID, bcrypt.MinCost, 1024)
+	bcryptHasher := newTestHasher(t, BCRYPT, bcrypt.MinCost, 1024)
+	argon2Stored, err := argon2Hasher.Hash("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptStored, err := bcryptHasher.Hash("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argon2Stored, "$argon2id$") || !strings.HasPrefix(bcryptStored, "$2a$") {
+		t.Fatalf("unexpected hash formats %q and %q", argon2Stored, bcryptStored)
+	}
+
+	tests := []struct {
+		name        string
+		hasher      *Hasher
+		stored      string
+		password    string
+		ok          bool
+		needsRehash bool
+	}{
+		{"argon2id", argon2Hasher, argon2Stored, "password1", true, false},
+		{"argon2id with a wrong password", argon2Hasher, argon2Stored, "password2", false, false},
+		{"argon2id with stale memory", newTestHasher(t, ARGON2ID, bcrypt.MinCost, 2048), argon2Stored, "password1", true, true},
+		{"argon2id when bcrypt is configured", bcryptHasher, argon2Stored, "password1", true, true},
+		{"bcrypt", bcryptHasher, bcryptStored, "password1", true, false},
+		{"bcrypt with a wrong password", bcryptHasher, bcryptStored, "password2", false, false},
+		{"bcrypt with stale cost", newTestHasher(t, BCRYPT, bcrypt.MinCost+1, 1024), bcryptStored, "password1", true, true},
+		{"bcrypt when argon2id is configured", argon2Hasher, bcryptStored, "password1", true, true},
+		{"legacy plaintext", argon2Hasher, "password1", "password1", true, true},
+		{"legacy plaintext with a wrong password", argon2Hasher, "password1", "password2", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := tt.hasher.Verify(tt.stored, tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok || needsRehash != tt.needsRehash {
+				t.Fatalf("expected ok=%v needsRehash=%v, got ok=%v needsRehash=%v", tt.ok, tt.needsRehash, ok, needsRehash)
+			}
+		})
+	}
+}
+
+func TestHasherUpgradesLegacyPasswords(t *testing.T) {
+	h := newTestHasher(t, ARGON2ID, bcrypt.MinCost, 1024)
+	ok, needsRehash, err := h.Verify("password1", "password1")
+	if err != nil || !ok || !needsRehash {
+		t.Fatalf("expected a legacy password to match and need a rehash, got %v %v %v", ok, needsRehash, err)
+	}
+	upgraded, err := h.Hash("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded == "password1" || len(upgraded) > 255 {
+		t.Fatalf("upgraded hash %q does not fit users.password", upgraded)
+	}
+	ok, needsRehash, err = h.Verify(upgraded, "password1")
+	if err != nil || !ok || needsRehash {
+		t.Fatalf("expected the upgraded hash to match as is, got %v %v %v", ok, needsRehash, err)
+	}
+}
+
+func TestHasherRejectsMalformedHashes(t *testing.T) {
+	h := newTestHasher(t, ARGON2ID, bcrypt.MinCost, 1024)
+	for _, stored := range []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$salt",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5",
+	} {
+		if _, _, err := h.Verify(stored, "password1"); err != ErrInvalidHash {
+			t.Fatalf("expected ErrInvalidHash for %q, got %v", stored, err)
+		}
+	}
+}
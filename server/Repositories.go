@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bootcamp_task/cache"
+	"bootcamp_task/storage/memory"
+	"bootcamp_task/storage/repositories"
+	"bootcamp_task/storage/storages"
+
+	"go.uber.org/fx"
+)
+
+// PostgresRepositories keeps data in Postgres and sessions and caches in
+// Redis.
+var PostgresRepositories = fx.Provide(
+	fx.Annotate(
+		storages.NewStorage,
+		fx.As(new(repositories.HouseRepository)),
+		fx.As(new(repositories.FlatRepository)),
+		fx.As(new(repositories.UserRepository)),
+		fx.As(new(repositories.OutboxRepository)),
+	),
+	fx.Annotate(
+		cache.NewCache,
+		fx.As(new(repositories.SessionStore)),
+		fx.As(new(repositories.FlatsCache)),
+	),
+)
+
+// InMemoryRepositories keeps everything in process memory, so the server
+// runs without external services. Data is lost on restart.
+var InMemoryRepositories = fx.Provide(
+	fx.Annotate(
+		memory.NewStorage,
+		fx.As(new(repositories.HouseRepository)),
+		fx.As(new(repositories.FlatRepository)),
+		fx.As(new(repositories.UserRepository)),
+		fx.As(new(repositories.OutboxRepository)),
+	),
+	fx.Annotate(
+		memory.NewCache,
+		fx.As(new(repositories.SessionStore)),
+		fx.As(new(repositories.FlatsCache)),
+	),
+)
@@ -0,0 +1,7 @@
+//go:build integration
+
+package server
+
+// testRepositories runs the suite against Postgres and Redis from
+// handlers/config.yaml, the database must be migrated beforehand.
+var testRepositories = PostgresRepositories
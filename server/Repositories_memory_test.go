@@ -0,0 +1,7 @@
+//go:build !integration
+
+package server
+
+// testRepositories runs the suite against the in-memory stand-ins. Run with
+// -tags integration to use Postgres and Redis from handlers/config.yaml.
+var testRepositories = InMemoryRepositories
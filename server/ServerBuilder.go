@@ -1,23 +1,51 @@
 package server
 
 import (
-	"bootcamp_task/cache"
+	"bootcamp_task/auth"
 	"bootcamp_task/config"
+	"bootcamp_task/events"
 	"bootcamp_task/handlers"
-	"bootcamp_task/storage/storages"
+	"bootcamp_task/notifications"
+	"bootcamp_task/passwords"
 	"context"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/gofiber/swagger"
 	"go.uber.org/fx"
 	"strconv"
+	"time"
 )
 
-func buildFiberServer(lc fx.Lifecycle, h *handlers.Handlers, c *config.Config) *fiber.App {
-	app := fiber.New()
+// requestContext limits the user context of every request by timeout. The
+// handlers pass it down to Postgres and Redis, so queries of a request that
+// ran out of time are canceled and the request fails with 503.
+func requestContext(timeout time.Duration) fiber.Handler {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+func buildFiberServer(
+	lc fx.Lifecycle,
+	h *handlers.Handlers,
+	a auth.Authenticator,
+	c *config.Config) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler:             handlers.ErrorHandler,
+		EnableSplittingOnParsers: true,
+	})
+	app.Use(requestid.New())
 	app.Use(cors.New())
 	app.Use(logger.New())
+	app.Use(requestContext(time.Duration(c.RequestTimeout) * time.Millisecond))
 
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "ok"})
@@ -28,14 +56,38 @@ func buildFiberServer(lc fx.Lifecycle, h *handlers.Handlers, c *config.Config) *
 	app.Get("/dummyLogin", h.DummyLogin)
 	app.Post("/register", h.Register)
 	app.Post("/login", h.Login)
+	app.Post("/token/refresh", h.RefreshToken)
+
+	authenticated := auth.Middleware(a)
+	moderator := auth.RequireRole(auth.MODERATOR)
+
+	app.Post("/logout", authenticated, h.Logout)
+	app.Delete("/sessions", authenticated, h.DeleteSessions)
 
-	houseGroup := app.Group("/house")
-	houseGroup.Post("/create", h.CreateHome)
+	app.Get("/houses", authenticated, h.SearchHouses)
+	app.Get("/flats", authenticated, h.SearchFlats)
+	app.Get("/flats/archive", authenticated, moderator, h.GetFlatArchive)
+
+	houseGroup := app.Group("/house", authenticated)
+	houseGroup.Post("/create", moderator, h.CreateHome)
 	houseGroup.Get("/:id", h.GetHouseFlats)
+	houseGroup.Patch("/:id", moderator, h.UpdateHome)
+	houseGroup.Delete("/:id", moderator, h.DeleteHome)
+	houseGroup.Post("/:id/restore", moderator, h.RestoreHome)
+	houseGroup.Post("/:id/subscribe", h.Subscribe)
+	houseGroup.Post("/:id/flats/import", h.ImportFlats)
+	houseGroup.Post("/:id/moderation/start", moderator, h.StartModeration)
 
-	flatsGroup := app.Group("/flat")
+	flatsGroup := app.Group("/flat", authenticated)
 	flatsGroup.Post("/create", h.CreateFlat)
-	flatsGroup.Post("/update", h.UpdateFlat)
+	flatsGroup.Post("/update", moderator, h.UpdateFlat)
+	flatsGroup.Post("/claim", moderator, h.ClaimFlat)
+	flatsGroup.Post("/resubmit", h.ResubmitFlat)
+	flatsGroup.Post("/market", h.SetMarketStatus)
+	flatsGroup.Get("/:house_id/:id", h.GetFlat)
+	flatsGroup.Get("/:house_id/:id/history", moderator, h.GetFlatHistory)
+
+	app.Get("/my/flats", authenticated, h.GetMyFlats)
 
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
@@ -51,13 +103,24 @@ func buildFiberServer(lc fx.Lifecycle, h *handlers.Handlers, c *config.Config) *
 }
 
 func BuildServerAndEnv() *fx.App {
+	return BuildServer(PostgresRepositories)
+}
+
+// BuildServer builds the application on top of the given repositories,
+// either PostgresRepositories or InMemoryRepositories.
+func BuildServer(repositories fx.Option) *fx.App {
 	return fx.New(
+		repositories,
 		fx.Provide(
 			config.ParseConfig,
-			cache.NewCache,
-			storages.NewStorage,
+			passwords.NewHasher,
+			auth.NewAuthenticator,
+			notifications.NewSender,
+			notifications.NewNotifier,
+			events.AsConsumer(notifications.NewFlatApprovedConsumer),
+			events.NewDispatcher,
 			handlers.NewHandlers,
 		),
-		fx.Invoke(buildFiberServer),
+		fx.Invoke(buildFiberServer, func(*events.Dispatcher) {}),
 	)
 }
@@ -0,0 +1,598 @@
+package server
+
+import (
+	"bootcamp_task/auth"
+	"bootcamp_task/config"
+	"bootcamp_task/handlers"
+	"bootcamp_task/passwords"
+	"bootcamp_task/storage/repositories"
+	"bootcamp_task/storage/storages"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+type response struct {
+	status int
+	body   map[string]interface{}
+}
+
+func (r response) field(name string) interface{} {
+	return r.body[name]
+}
+
+func (r response) object(name string) map[string]interface{} {
+	value, _ := r.body[name].(map[string]interface{})
+	return value
+}
+
+func (r response) list(name string) []interface{} {
+	value, _ := r.body[name].([]interface{})
+	return value
+}
+
+type testApi struct {
+	t   *testing.T
+	app *fiber.App
+}
+
+// newTestApi builds the app like BuildServer does, without starting the
+// listener and the outbox dispatcher. configure may change the config read
+// from handlers/config.yaml.
+func newTestApi(t *testing.T, configure ...func(cfg *config.Config)) *testApi {
+	var app *fiber.App
+	cfg := config.ParseConfigFile("../handlers/config.yaml")
+	for _, c := range configure {
+		c(cfg)
+	}
+	fxtest.New(
+		t,
+		testRepositories,
+		fx.Provide(
+			func() *config.Config { return cfg },
+			passwords.NewHasher,
+			auth.NewAuthenticator,
+			handlers.NewHandlers,
+			buildFiberServer,
+		),
+		fx.Populate(&app),
+	)
+	return &testApi{t: t, app: app}
+}
+
+// with returns the api reporting to t, for use in subtests.
+func (a *testApi) with(t *testing.T) *testApi {
+	return &testApi{t: t, app: a.app}
+}
+
+func (a *testApi) call(method string, path string, token string, body interface{}) response {
+	a.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	resp, err := a.app.Test(req, -1)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	result := response{status: resp.StatusCode, body: map[string]interface{}{}}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &result.body); err != nil {
+			a.t.Fatalf("%s %s: malformed response %q", method, path, raw)
+		}
+	}
+	return result
+}
+
+// expect calls the endpoint and fails the test unless it answers status.
+func (a *testApi) expect(status int, method string, path string, token string, body interface{}) response {
+	a.t.Helper()
+	resp := a.call(method, path, token, body)
+	if resp.status != status {
+		a.t.Fatalf("%s %s: expected %d, got %d %v", method, path, status, resp.status, resp.body)
+	}
+	return resp
+}
+
+// expectError checks the status and the error code of a failed request.
+func (a *testApi) expectError(status int, code string, method string, path string, token string, body interface{}) response {
+	a.t.Helper()
+	resp := a.expect(status, method, path, token, body)
+	if resp.field("code") != code {
+		a.t.Fatalf("%s %s: expected code %q, got %v", method, path, code, resp.body)
+	}
+	if id, _ := resp.field("request_id").(string); id == "" {
+		a.t.Fatalf("%s %s: no request_id in %v", method, path, resp.body)
+	}
+	return resp
+}
+
+type testUser struct {
+	email    string
+	password string
+	token    string
+	refresh  string
+}
+
+// registerUser registers a user with a unique email and logs them in.
+func (a *testApi) registerUser(userType string) *testUser {
+	a.t.Helper()
+	user := &testUser{email: uuid.New().String() + "@example.com", password: "password1"}
+	a.expect(fiber.StatusOK, "POST", "/register", "", fiber.Map{
+		"email":     user.email,
+		"password":  user.password,
+		"user_type": userType,
+	})
+	resp := a.expect(fiber.StatusOK, "POST", "/login", "", fiber.Map{
+		"email":    user.email,
+		"password": user.password,
+	})
+	user.token, _ = resp.field("token").(string)
+	user.refresh, _ = resp.field("refresh_token").(string)
+	if user.token == "" || user.refresh == "" {
+		a.t.Fatalf("login returned no tokens: %v", resp.body)
+	}
+	return user
+}
+
+func (a *testApi) createHouse(moderator *testUser) int {
+	a.t.Helper()
+	resp := a.expect(fiber.StatusOK, "POST", "/house/create", moderator.token, fiber.Map{
+		"address":   "Lenina 1",
+		"year":      2000,
+		"developer": "Stroy",
+	})
+	return int(resp.object("house")["id"].(float64))
+}
+
+func (a *testApi) createFlat(user *testUser, houseId int, number int, price int, rooms int) {
+	a.t.Helper()
+	a.expect(fiber.StatusOK, "POST", "/flat/create", user.token, fiber.Map{
+		"house_id": houseId,
+		"id":       number,
+		"price":    price,
+		"rooms":    rooms,
+	})
+}
+
+// review claims the flat and sets the final status.
+func (a *testApi) review(moderator *testUser, houseId int, number int, price int, rooms int, status string) {
+	a.t.Helper()
+	a.expect(fiber.StatusOK, "POST", "/flat/claim", moderator.token, fiber.Map{"house_id": houseId, "id": number})
+	body := fiber.Map{"house_id": houseId, "id": number, "price": price, "rooms": rooms, "status": status}
+	if status == "declined" {
+		body["decline_reason"] = fiber.Map{"code": "wrong_price", "text": "too cheap"}
+	}
+	a.expect(fiber.StatusOK, "POST", "/flat/update", moderator.token, body)
+}
+
+// houseFlats returns numbers of flats the user sees in the house.
+func (a *testApi) houseFlats(user *testUser, houseId int) []int {
+	a.t.Helper()
+	resp := a.expect(fiber.StatusOK, "GET", fmt.Sprintf("/house/%d", houseId), user.token, nil)
+	numbers := make([]int, 0)
+	for _, flat := range resp.list("flats") {
+		numbers = append(numbers, int(flat.(map[string]interface{})["id"].(float64)))
+	}
+	return numbers
+}
+
+func expectNumbers(t *testing.T, got []int, want ...int) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected flats %v, got %v", want, got)
+	}
+}
+
+func TestModerationScenario(t *testing.T) {
+	api := newTestApi(t)
+	moderator := api.registerUser("moderator")
+	seller := api.registerUser("client")
+	buyer := api.registerUser("client")
+
+	houseId := api.createHouse(moderator)
+	api.createFlat(seller, houseId, 1, 5000000, 2)
+	api.createFlat(seller, houseId, 2, 100, 1)
+	api.createFlat(seller, houseId, 3, 7000000, 3)
+
+	expectNumbers(t, api.houseFlats(buyer, houseId))
+	expectNumbers(t, api.houseFlats(moderator, houseId), 1, 2, 3)
+
+	started := api.expect(fiber.StatusOK, "POST", fmt.Sprintf("/house/%d/moderation/start", houseId), moderator.token, nil)
+	if len(started.list("flats")) != 3 {
+		t.Fatalf("expected 3 flats moved to moderation, got %v", started.body)
+	}
+
+	api.review(moderator, houseId, 1, 5000000, 2, "approved")
+	api.review(moderator, houseId, 2, 100, 1, "declined")
+	expectNumbers(t, api.houseFlats(buyer, houseId), 1)
+
+	declined := api.expect(fiber.StatusOK, "GET", fmt.Sprintf("/flat/%d/2", houseId), seller.token, nil)
+	if declined.object("flat")["decline_reason"] == nil {
+		t.Fatalf("creator must see the decline reason: %v", declined.body)
+	}
+	api.expectError(fiber.StatusNotFound, "flat_not_found", "GET", fmt.Sprintf("/flat/%d/2", houseId), buyer.token, nil)
+
+	api.expect(fiber.StatusOK, "POST", "/flat/resubmit", seller.token, fiber.Map{
+		"house_id": houseId, "id": 2, "price": 4000000, "rooms": 1,
+	})
+	mine := api.expect(fiber.StatusOK, "GET", "/my/flats", seller.token, nil)
+	if len(mine.list("flats")) != 3 {
+		t.Fatalf("expected 3 own flats, got %v", mine.body)
+	}
+
+	history := api.expect(fiber.StatusOK, "GET", fmt.Sprintf("/flat/%d/2/history", houseId), moderator.token, nil)
+	if len(history.list("history")) != 4 {
+		t.Fatalf("expected start, claim, decline and resubmit in history, got %v", history.body)
+	}
+}
+
+// TestFlatOwnership checks that only the creator of a flat or a moderator
+// can resubmit it or take it off the market.
+func TestFlatOwnership(t *testing.T) {
+	api := newTestApi(t)
+	moderator := api.registerUser("moderator")
+	seller := api.registerUser("client")
+	other := api.registerUser("client")
+	dummy := api.expect(fiber.StatusOK, "GET", "/dummyLogin?user_type=client", "", nil)
+	dummyToken, _ := dummy.field("token").(string)
+
+	houseId := api.createHouse(moderator)
+	api.createFlat(seller, houseId, 1, 1000, 1)
+	api.createFlat(seller, houseId, 2, 2000, 2)
+	api.review(moderator, houseId, 1, 1000, 1, "declined")
+	resubmit := fiber.Map{"house_id": houseId, "id": 1, "price": 1500, "rooms": 1}
+	for _, token := range []string{other.token, dummyToken} {
+		api.expectError(fiber.StatusForbidden, "not_flat_owner", "POST", "/flat/resubmit", token, resubmit)
+		api.expectError(fiber.StatusForbidden, "not_flat_owner", "POST", "/flat/market", token, fiber.Map{
+			"house_id": houseId, "id": 2, "market_status": "withdrawn",
+		})
+	}
+	api.expect(fiber.StatusOK, "POST", "/flat/resubmit", seller.token, resubmit)
+	api.expect(fiber.StatusOK, "POST", "/flat/market", seller.token, fiber.Map{
+		"house_id": houseId, "id": 1, "market_status": "withdrawn",
+	})
+	api.expect(fiber.StatusOK, "POST", "/flat/market", moderator.token, fiber.Map{
+		"house_id": houseId, "id": 2, "market_status": "sold",
+	})
+
+	mine := api.expect(fiber.StatusOK, "GET", "/my/flats", other.token, nil)
+	if len(mine.list("flats")) != 0 {
+		t.Fatalf("expected no own flats, got %v", mine.body)
+	}
+}
+
+// TestClaimExpires checks that a claim blocks other moderators only until
+// moderation/claim_timeout passes.
+func TestClaimExpires(t *testing.T) {
+	claimTimeout := time.Second
+	api := newTestApi(t, func(cfg *config.Config) { cfg.Moderation.ClaimTimeout = claimTimeout.Minutes() })
+	first := api.registerUser("moderator")
+	second := api.registerUser("moderator")
+	seller := api.registerUser("client")
+
+	houseId := api.createHouse(first)
+	api.createFlat(seller, houseId, 1, 1000, 1)
+	flat := fiber.Map{"house_id": houseId, "id": 1}
+	approve := fiber.Map{"house_id": houseId, "id": 1, "price": 1000, "rooms": 1, "status": "approved"}
+	api.expect(fiber.StatusOK, "POST", "/flat/claim", first.token, flat)
+	api.expectError(fiber.StatusConflict, "flat_claimed", "POST", "/flat/claim", second.token, flat)
+	api.expectError(fiber.StatusConflict, "flat_claimed", "POST", "/flat/update", second.token, approve)
+
+	time.Sleep(claimTimeout + 100*time.Millisecond)
+	api.expectError(fiber.StatusForbidden, "flat_not_claimed", "POST", "/flat/update", first.token, approve)
+	api.expect(fiber.StatusOK, "POST", "/flat/claim", second.token, flat)
+	api.expect(fiber.StatusOK, "POST", "/flat/update", second.token, approve)
+
+	api.expect(fiber.StatusOK, "POST", "/flat/update", first.token, fiber.Map{
+		"house_id": houseId, "id": 1, "price": 1200, "rooms": 1, "status": "approved",
+	})
+}
+
+func TestClientCacheIsInvalidated(t *testing.T) {
+	api := newTestApi(t)
+	moderator := api.registerUser("moderator")
+	seller := api.registerUser("client")
+	buyer := api.registerUser("client")
+
+	houseId := api.createHouse(moderator)
+	api.createFlat(seller, houseId, 1, 1000, 1)
+	api.createFlat(seller, houseId, 2, 2000, 2)
+	api.review(moderator, houseId, 1, 1000, 1, "approved")
+
+	expectNumbers(t, api.houseFlats(buyer, houseId), 1)
+	expectNumbers(t, api.houseFlats(buyer, houseId), 1)
+
+	api.review(moderator, houseId, 2, 2000, 2, "approved")
+	expectNumbers(t, api.houseFlats(buyer, houseId), 1, 2)
+
+	api.expect(fiber.StatusOK, "POST", "/flat/market", seller.token, fiber.Map{
+		"house_id": houseId, "id": 1, "market_status": "sold",
+	})
+	expectNumbers(t, api.houseFlats(buyer, houseId), 2)
+
+	house := api.expect(fiber.StatusOK, "GET", "/houses?address=lenina&limit=100", moderator.token, nil)
+	var updatedAt interface{}
+	for _, h := range house.list("houses") {
+		if int(h.(map[string]interface{})["id"].(float64)) == houseId {
+			updatedAt = h.(map[string]interface{})["updated_at"]
+		}
+	}
+	api.expect(fiber.StatusOK, "PATCH", fmt.Sprintf("/house/%d", houseId), moderator.token, fiber.Map{
+		"developer":  "Other",
+		"updated_at": updatedAt,
+	})
+	api.expect(fiber.StatusOK, "DELETE", fmt.Sprintf("/house/%d", houseId), moderator.token, nil)
+	api.expectError(fiber.StatusNotFound, "house_not_found", "GET", fmt.Sprintf("/house/%d", houseId), buyer.token, nil)
+	api.expect(fiber.StatusOK, "POST", fmt.Sprintf("/house/%d/restore", houseId), moderator.token, nil)
+	expectNumbers(t, api.houseFlats(buyer, houseId), 2)
+}
+
+func TestHousesWithApprovedFlats(t *testing.T) {
+	api := newTestApi(t)
+	moderator := api.registerUser("moderator")
+	seller := api.registerUser("client")
+	developer := "Dev " + uuid.New().String()[:8]
+	houses := make([]int, 3)
+	for i := range houses {
+		resp := api.expect(fiber.StatusOK, "POST", "/house/create", moderator.token, fiber.Map{
+			"address": fmt.Sprintf("Lenina %d", i+1), "year": 2000, "developer": developer,
+		})
+		houses[i] = int(resp.object("house")["id"].(float64))
+		api.createFlat(seller, houses[i], 1, 1000, 1)
+	}
+	api.review(moderator, houses[0], 1, 1000, 1, "approved")
+	api.review(moderator, houses[1], 1, 1000, 1, "approved")
+	api.expect(fiber.StatusOK, "POST", "/flat/market", seller.token, fiber.Map{
+		"house_id": houses[1], "id": 1, "market_status": "sold",
+	})
+
+	query := url.Values{"developer": {developer}, "has_approved_flats": {"true"}}
+	resp := api.expect(fiber.StatusOK, "GET", "/houses?"+query.Encode(), seller.token, nil)
+	found := make([]int, 0)
+	for _, house := range resp.list("houses") {
+		fields := house.(map[string]interface{})
+		if _, ok := fields["reviewer"]; ok {
+			t.Fatalf("the reviewer of a house must not be shown to clients: %v", fields)
+		}
+		found = append(found, int(fields["id"].(float64)))
+	}
+	if fmt.Sprint(found) != fmt.Sprint([]int{houses[0]}) {
+		t.Fatalf("expected only house %d with an approved flat on the market, got %v", houses[0], found)
+	}
+}
+
+func TestImportFlats(t *testing.T) {
+	api := newTestApi(t)
+	moderator := api.registerUser("moderator")
+	seller := api.registerUser("client")
+	houseId := api.createHouse(moderator)
+	path := fmt.Sprintf("/house/%d/flats/import", houseId)
+	flats := func(numbers ...int) fiber.Map {
+		list := make([]fiber.Map, 0, len(numbers))
+		for _, number := range numbers {
+			list = append(list, fiber.Map{"id": number, "price": number * 1000, "rooms": 1})
+		}
+		return fiber.Map{"flats": list}
+	}
+
+	resp := api.expect(fiber.StatusOK, "POST", path, seller.token, flats(3, 1, 2))
+	if len(resp.list("flats")) != 3 {
+		t.Fatalf("expected 3 imported flats, got %v", resp.body)
+	}
+	api.expectError(fiber.StatusConflict, "flat_exists", "POST", path, seller.token, flats(4, 2))
+	api.expectError(fiber.StatusBadRequest, "validation_failed", "POST", path, seller.token, flats(5, 5))
+	api.expectError(fiber.StatusNotFound, "house_not_found", "POST", "/house/999999999/flats/import", seller.token, flats(1))
+	expectNumbers(t, api.houseFlats(moderator, houseId), 1, 2, 3)
+
+	mine := api.expect(fiber.StatusOK, "GET", "/my/flats", seller.token, nil)
+	if len(mine.list("flats")) != 3 {
+		t.Fatalf("imported flats must belong to the seller, got %v", mine.body)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	api := newTestApi(t)
+	moderator := api.registerUser("moderator")
+
+	houseId := api.createHouse(moderator)
+	for number := 1; number <= 5; number++ {
+		api.createFlat(moderator, houseId, number, number*1000, 1)
+	}
+
+	seen := make([]int, 0)
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		query := url.Values{"limit": {"2"}, "sort": {"-price"}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		resp := api.expect(fiber.StatusOK, "GET", fmt.Sprintf("/house/%d?%s", houseId, query.Encode()), moderator.token, nil)
+		for _, flat := range resp.list("flats") {
+			seen = append(seen, int(flat.(map[string]interface{})["id"].(float64)))
+		}
+		cursor, _ = resp.field("next_cursor").(string)
+		if cursor == "" {
+			break
+		}
+	}
+	expectNumbers(t, seen, 5, 4, 3, 2, 1)
+}
+
+func TestJwtRevocation(t *testing.T) {
+	api := newTestApi(t, func(cfg *config.Config) { cfg.Auth.Mode = auth.JWT })
+	user := api.registerUser("client")
+	second := api.expect(fiber.StatusOK, "POST", "/login", "", fiber.Map{"email": user.email, "password": user.password})
+	secondToken, _ := second.field("token").(string)
+
+	api.expect(fiber.StatusOK, "POST", "/logout", user.token, nil)
+	api.expectError(fiber.StatusUnauthorized, "unauthorized", "GET", "/my/flats", user.token, nil)
+	api.expect(fiber.StatusOK, "GET", "/my/flats", secondToken, nil)
+
+	api.expect(fiber.StatusOK, "DELETE", "/sessions", secondToken, nil)
+	api.expectError(fiber.StatusUnauthorized, "unauthorized", "GET", "/my/flats", secondToken, nil)
+	third := api.expect(fiber.StatusOK, "POST", "/login", "", fiber.Map{"email": user.email, "password": user.password})
+	thirdToken, _ := third.field("token").(string)
+	api.expect(fiber.StatusOK, "GET", "/my/flats", thirdToken, nil)
+}
+
+// TestDeletedHouseRepository calls the repository directly: the handlers
+// check the house before subscribing, which would hide a missing check.
+func TestDeletedHouseRepository(t *testing.T) {
+	var houses repositories.HouseRepository
+	var flats repositories.FlatRepository
+	fxtest.New(
+		t,
+		testRepositories,
+		fx.Provide(func() *config.Config { return config.ParseConfigFile("../handlers/config.yaml") }),
+		fx.Populate(&houses, &flats),
+	)
+	ctx := context.Background()
+	home, err := houses.CreateHome(ctx, "Lenina 1", 2000, "Stroy", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := houses.CreateSubscription(ctx, home.Id, "before@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := houses.DeleteHome(ctx, home.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := houses.CreateSubscription(ctx, home.Id, "after@example.com"); !errors.Is(err, storages.ErrHouseNotFound) {
+		t.Fatalf("expected ErrHouseNotFound on subscribing to a deleted house, got %v", err)
+	}
+	if _, err := houses.GetHomeReviewer(ctx, home.Id); !errors.Is(err, storages.ErrHouseNotFound) {
+		t.Fatalf("expected ErrHouseNotFound for the reviewer of a deleted house, got %v", err)
+	}
+	if _, err := flats.StartModeration(ctx, home.Id, uuid.New().String()); !errors.Is(err, storages.ErrHouseNotFound) {
+		t.Fatalf("expected ErrHouseNotFound on starting moderation of a deleted house, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	api := newTestApi(t)
+	moderator := api.registerUser("moderator")
+	client := api.registerUser("client")
+	other := api.registerUser("client")
+	houseId := api.createHouse(moderator)
+	api.createFlat(client, houseId, 1, 1000, 1)
+	house := fmt.Sprintf("/house/%d", houseId)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		api := api.with(t)
+		api.expectError(fiber.StatusUnauthorized, "unauthorized", "GET", house, "", nil)
+		api.expectError(fiber.StatusUnauthorized, "unauthorized", "GET", house, "not-a-token", nil)
+		api.expectError(fiber.StatusUnauthorized, "wrong_password", "POST", "/login", "", fiber.Map{
+			"email": client.email, "password": "wrong-password",
+		})
+		api.expectError(fiber.StatusUnauthorized, "invalid_refresh_token", "POST", "/token/refresh", "", fiber.Map{
+			"refresh_token": uuid.New().String(),
+		})
+		first := api.expect(fiber.StatusOK, "POST", "/token/refresh", "", fiber.Map{"refresh_token": client.refresh})
+		if first.field("refresh_token") == "" {
+			t.Fatalf("no rotated refresh token: %v", first.body)
+		}
+		api.expectError(fiber.StatusUnauthorized, "refresh_token_reused", "POST", "/token/refresh", "", fiber.Map{
+			"refresh_token": client.refresh,
+		})
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		api := api.with(t)
+		api.expectError(fiber.StatusForbidden, "forbidden", "POST", "/house/create", client.token, fiber.Map{
+			"address": "Lenina 2", "year": 2001,
+		})
+		api.expectError(fiber.StatusForbidden, "forbidden", "POST", "/flat/update", client.token, fiber.Map{})
+		api.expectError(fiber.StatusForbidden, "forbidden", "GET", "/flats/archive", client.token, nil)
+		api.expectError(fiber.StatusForbidden, "not_flat_owner", "POST", "/flat/market", other.token, fiber.Map{
+			"house_id": houseId, "id": 1, "market_status": "withdrawn",
+		})
+		// Logging out with a refresh token of another user revokes nothing.
+		api.expectError(fiber.StatusForbidden, "refresh_token_not_owned", "POST", "/logout", client.token, fiber.Map{
+			"refresh_token": other.refresh,
+		})
+		api.expect(fiber.StatusOK, "GET", "/my/flats", client.token, nil)
+		api.expect(fiber.StatusOK, "POST", "/token/refresh", "", fiber.Map{"refresh_token": other.refresh})
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		api := api.with(t)
+		resp := api.expectError(fiber.StatusBadRequest, "validation_failed", "POST", "/register", "", fiber.Map{
+			"email": "not-an-email", "password": "1",
+		})
+		if len(resp.list("fields")) != 3 {
+			t.Fatalf("expected errors for email, password and user_type: %v", resp.body)
+		}
+		api.expectError(fiber.StatusBadRequest, "invalid_user_type", "GET", "/dummyLogin?user_type=admin", "", nil)
+		api.expectError(fiber.StatusBadRequest, "bad_request", "GET", "/house/abc", client.token, nil)
+		api.expectError(fiber.StatusBadRequest, "validation_failed", "GET", house+"?sort=address", client.token, nil)
+		api.expectError(fiber.StatusBadRequest, "invalid_cursor", "GET", house+"?cursor=garbage", moderator.token, nil)
+		api.expectError(fiber.StatusBadRequest, "validation_failed", "POST", "/flat/update", moderator.token, fiber.Map{
+			"house_id": houseId, "id": 1, "price": 1000, "rooms": 1, "status": "declined",
+		})
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		api := api.with(t)
+		api.expectError(fiber.StatusNotFound, "user_not_found", "POST", "/login", "", fiber.Map{
+			"email": uuid.New().String() + "@example.com", "password": "password1",
+		})
+		api.expectError(fiber.StatusNotFound, "house_not_found", "GET", "/house/999999999", client.token, nil)
+		api.expectError(fiber.StatusNotFound, "house_not_found", "POST", "/flat/create", client.token, fiber.Map{
+			"house_id": 999999999, "id": 1, "price": 1, "rooms": 1,
+		})
+		api.expectError(fiber.StatusNotFound, "flat_not_found", "GET", fmt.Sprintf("/flat/%d/1000/history", houseId), moderator.token, nil)
+		api.expectError(fiber.StatusNotFound, "flat_not_found", "POST", "/flat/claim", moderator.token, fiber.Map{
+			"house_id": houseId, "id": 1000,
+		})
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		api := api.with(t)
+		api.expectError(fiber.StatusConflict, "user_exists", "POST", "/register", "", fiber.Map{
+			"email": client.email, "password": "password1", "user_type": "client",
+		})
+		api.expectError(fiber.StatusConflict, "flat_exists", "POST", "/flat/create", client.token, fiber.Map{
+			"house_id": houseId, "id": 1, "price": 1, "rooms": 1,
+		})
+		api.expectError(fiber.StatusConflict, "illegal_status_transition", "POST", "/flat/resubmit", client.token, fiber.Map{
+			"house_id": houseId, "id": 1, "price": 1, "rooms": 1,
+		})
+		api.expectError(fiber.StatusConflict, "house_modified", "PATCH", house, moderator.token, fiber.Map{
+			"address": "Lenina 3", "updated_at": "2000-01-01T00:00:00Z",
+		})
+		api.expectError(fiber.StatusConflict, "house_not_deleted", "POST", house+"/restore", moderator.token, nil)
+		api.expect(fiber.StatusOK, "POST", "/flat/market", client.token, fiber.Map{
+			"house_id": houseId, "id": 1, "market_status": "withdrawn",
+		})
+		api.expectError(fiber.StatusConflict, "flat_off_market", "POST", "/flat/market", client.token, fiber.Map{
+			"house_id": houseId, "id": 1, "market_status": "sold",
+		})
+	})
+}
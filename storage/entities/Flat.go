@@ -1,9 +1,24 @@
 package entities
 
+import "time"
+
 type Flat struct {
-	Number int    `json:"id"`
-	Price  int    `json:"price"`
-	Rooms  int    `json:"rooms"`
-	HomeId int    `json:"house_id"`
-	Status string `json:"status"`
+	Id            int64          `json:"global_id"`
+	Number        int            `json:"id"`
+	Price         int            `json:"price"`
+	Rooms         int            `json:"rooms"`
+	HomeId        int            `json:"house_id"`
+	Status        string         `json:"status"`
+	ModeratorId   string         `json:"moderator_id,omitempty"`
+	ClaimedAt     *time.Time     `json:"claimed_at,omitempty"`
+	DeclineReason *DeclineReason `json:"decline_reason,omitempty"`
+	Resubmissions int            `json:"resubmissions,omitempty"`
+	MarketStatus  string         `json:"market_status"`
+	MarketChanged *time.Time     `json:"market_changed_at,omitempty"`
+	CreatedBy     string         `json:"-"`
+}
+
+type DeclineReason struct {
+	Code string `json:"code"`
+	Text string `json:"text"`
 }
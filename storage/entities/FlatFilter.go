@@ -0,0 +1,26 @@
+package entities
+
+// FlatFilter describes a page of GET /flats. Empty fields do not filter,
+// an empty Statuses or MarketStatuses means any status. Flats of deleted houses are skipped
+// unless IncludeDeleted is set.
+type FlatFilter struct {
+	PriceMin       int
+	PriceMax       int
+	Rooms          []int
+	HouseIds       []int
+	Developer      string
+	YearFrom       int
+	YearTo         int
+	Statuses       []string
+	MarketStatuses []string
+	IncludeDeleted bool
+	Sort           string
+	Desc           bool
+	Cursor         string
+	Limit          int
+}
+
+type FlatPage struct {
+	Flats      []Flat `json:"flats"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,18 @@
+package entities
+
+import "time"
+
+type FlatModerationEvent struct {
+	Id             int64     `json:"id"`
+	HomeId         int       `json:"house_id"`
+	FlatNumber     int       `json:"flat_id"`
+	ActorId        string    `json:"actor_id"`
+	PreviousStatus string    `json:"previous_status"`
+	NewStatus      string    `json:"new_status"`
+	PreviousPrice  int       `json:"previous_price"`
+	NewPrice       int       `json:"new_price"`
+	PreviousRooms  int       `json:"previous_rooms"`
+	NewRooms       int       `json:"new_rooms"`
+	Reason         string    `json:"reason,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -3,11 +3,12 @@ package entities
 import "time"
 
 type Home struct {
-	Id        int       `json:"id"`
-	Address   string    `json:"address"`
-	Year      int       `json:"year"`
-	Developer string    `json:"developer"`
-	Reviewer  string    `json:"reviewer"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Id        int        `json:"id"`
+	Address   string     `json:"address"`
+	Year      int        `json:"year"`
+	Developer string     `json:"developer"`
+	Reviewer  string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
@@ -0,0 +1,20 @@
+package entities
+
+// HomeFilter describes a page of GET /houses. Empty fields do not filter.
+type HomeFilter struct {
+	Address          string
+	Developer        string
+	YearFrom         int
+	YearTo           int
+	HasApprovedFlats bool
+	IncludeDeleted   bool
+	Sort             string
+	Desc             bool
+	Cursor           string
+	Limit            int
+}
+
+type HomePage struct {
+	Homes      []Home `json:"houses"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
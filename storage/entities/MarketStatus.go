@@ -0,0 +1,16 @@
+package entities
+
+// MarketStatus tells whether a flat is still offered. It is independent of
+// the moderation status: only active flats are shown to clients, the others
+// form the archive.
+type MarketStatus string
+
+const (
+	ACTIVE    MarketStatus = "active"
+	SOLD      MarketStatus = "sold"
+	RENTED    MarketStatus = "rented"
+	WITHDRAWN MarketStatus = "withdrawn"
+)
+
+// ARCHIVED lists market statuses of flats taken off the market.
+var ARCHIVED = []string{string(SOLD), string(RENTED), string(WITHDRAWN)}
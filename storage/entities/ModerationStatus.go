@@ -1,10 +1,99 @@
 package entities
 
+import "fmt"
+
 type ModerationStatus string
 
 const (
 	CREATED       ModerationStatus = "created"
-	APPROVED                       = "approved"
-	DECLINED                       = "declined"
-	ON_MODERATION                  = "on_moderation"
+	APPROVED      ModerationStatus = "approved"
+	DECLINED      ModerationStatus = "declined"
+	ON_MODERATION ModerationStatus = "on_moderation"
 )
+
+// ModerationAction is a step of the moderation workflow. Every action is
+// legal only from a fixed set of statuses and leads to a single status:
+//
+//	created --claim--> on_moderation --approve--> approved
+//	                                 --decline--> declined --resubmit--> created
+type ModerationAction string
+
+const (
+	CLAIM    ModerationAction = "claim"
+	APPROVE  ModerationAction = "approve"
+	DECLINE  ModerationAction = "decline"
+	RESUBMIT ModerationAction = "resubmit"
+)
+
+type moderationTransition struct {
+	from []ModerationStatus
+	to   ModerationStatus
+}
+
+var moderationTransitions = map[ModerationAction]moderationTransition{
+	CLAIM:    {from: []ModerationStatus{CREATED, ON_MODERATION}, to: ON_MODERATION},
+	APPROVE:  {from: []ModerationStatus{ON_MODERATION}, to: APPROVED},
+	DECLINE:  {from: []ModerationStatus{ON_MODERATION}, to: DECLINED},
+	RESUBMIT: {from: []ModerationStatus{DECLINED}, to: CREATED},
+}
+
+// ActionTo returns the action that leads to status. Resubmission is not
+// returned for CREATED, since it must be requested explicitly.
+func ActionTo(status ModerationStatus) (ModerationAction, bool) {
+	switch status {
+	case ON_MODERATION:
+		return CLAIM, true
+	case APPROVED:
+		return APPROVE, true
+	case DECLINED:
+		return DECLINE, true
+	default:
+		return "", false
+	}
+}
+
+// TransitionError reports an action that is illegal in the current status.
+type TransitionError struct {
+	From   ModerationStatus
+	To     ModerationStatus
+	Action ModerationAction
+}
+
+func (e *TransitionError) Error() string {
+	if e.Action == "" {
+		return fmt.Sprintf("flat status cannot be changed from %s to %s", e.From, e.To)
+	}
+	return fmt.Sprintf("flat in status %s cannot be moved to %s by %s", e.From, e.To, e.Action)
+}
+
+func (e *TransitionError) Code() string {
+	return "illegal_status_transition"
+}
+
+// Apply validates action in status from and returns the resulting status.
+func (a ModerationAction) Apply(from ModerationStatus) (ModerationStatus, error) {
+	transition, ok := moderationTransitions[a]
+	if !ok {
+		return from, &TransitionError{From: from, Action: a}
+	}
+	for _, allowed := range transition.from {
+		if allowed == from {
+			return transition.to, nil
+		}
+	}
+	return from, &TransitionError{From: from, To: transition.to, Action: a}
+}
+
+// ValidateTransition checks that from may be changed to to by a moderator
+// update. Keeping the status is always legal.
+func ValidateTransition(from ModerationStatus, to ModerationStatus) error {
+	if from == to {
+		return nil
+	}
+	action, ok := ActionTo(to)
+	if !ok {
+		return &TransitionError{From: from, To: to}
+	}
+	_, err := action.Apply(from)
+	return err
+}
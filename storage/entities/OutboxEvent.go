@@ -0,0 +1,27 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EventType string
+
+const (
+	FLAT_CREATED        EventType = "flat_created"
+	FLAT_STATUS_CHANGED EventType = "flat_status_changed"
+)
+
+type OutboxEvent struct {
+	Id        int64           `json:"id"`
+	Type      EventType       `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	Attempts  int             `json:"attempts"`
+}
+
+// FlatEvent is the payload of FLAT_CREATED and FLAT_STATUS_CHANGED events.
+type FlatEvent struct {
+	Flat           Flat   `json:"flat"`
+	PreviousStatus string `json:"previous_status,omitempty"`
+}
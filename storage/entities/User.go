@@ -3,6 +3,6 @@ package entities
 type User struct {
 	Id       string `json:"id"`
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"-"`
 	IsAdmin  bool   `json:"is_admin"`
 }
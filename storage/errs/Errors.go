@@ -0,0 +1,92 @@
+package errs
+
+import "errors"
+
+// Kind classifies domain errors; the HTTP layer maps every kind to a
+// status code.
+type Kind int
+
+const (
+	NOT_FOUND Kind = iota + 1
+	CONFLICT
+	VALIDATION
+	UNAUTHORIZED
+	FORBIDDEN
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error with a machine-readable Code and a Message safe
+// to show to the client. Errors are compared by Kind and Code, so
+// errors.Is matches a wrapped copy against the declared value.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+	cause   error
+}
+
+func New(kind Kind, code string, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func NotFound(code string, message string) *Error {
+	return New(NOT_FOUND, code, message)
+}
+
+func Conflict(code string, message string) *Error {
+	return New(CONFLICT, code, message)
+}
+
+func Validation(code string, message string, fields ...FieldError) *Error {
+	e := New(VALIDATION, code, message)
+	e.Fields = fields
+	return e
+}
+
+func Unauthorized(code string, message string) *Error {
+	return New(UNAUTHORIZED, code, message)
+}
+
+func Forbidden(code string, message string) *Error {
+	return New(FORBIDDEN, code, message)
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+func (e *Error) Is(target error) bool {
+	var t *Error
+	if !errors.As(target, &t) {
+		return false
+	}
+	return e.Kind == t.Kind && e.Code == t.Code
+}
+
+// Wrap returns a copy of e caused by cause.
+func (e *Error) Wrap(cause error) *Error {
+	wrapped := *e
+	wrapped.cause = cause
+	return &wrapped
+}
+
+// WithMessage returns a copy of e with another client-facing message.
+func (e *Error) WithMessage(message string) *Error {
+	copied := *e
+	copied.Message = message
+	return &copied
+}
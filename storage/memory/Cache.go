@@ -0,0 +1,379 @@
+package memory
+
+import (
+	"bootcamp_task/cache"
+	"bootcamp_task/config"
+	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/repositories"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/fx"
+)
+
+// sweepInterval is how often expired entries are removed from the maps.
+const sweepInterval = time.Minute
+
+var (
+	_ repositories.SessionStore = (*Cache)(nil)
+	_ repositories.FlatsCache   = (*Cache)(nil)
+)
+
+type session struct {
+	userId    string
+	admin     bool
+	expiresAt time.Time
+}
+
+type refreshToken struct {
+	userId    string
+	admin     bool
+	family    string
+	used      bool
+	expiresAt time.Time
+}
+
+type revokedUser struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+type cachedPage struct {
+	body      []byte
+	expiresAt time.Time
+}
+
+// Cache replaces cache.Cache: sessions, refresh tokens and flat pages live
+// in maps and expire after the timeouts of the redis config section.
+// Expired entries are dropped when they are read and by a background sweep,
+// so entries nobody reads again do not pile up.
+type Cache struct {
+	mu sync.Mutex
+
+	sessions      map[string]session
+	userSessions  map[string]map[string]struct{}
+	refresh       map[string]*refreshToken
+	families      map[string]map[string]struct{}
+	userFamilies  map[string]map[string]struct{}
+	revokedTokens map[string]time.Time
+	revokedUsers  map[string]revokedUser
+	pages         map[string]cachedPage
+	sessionTTL    time.Duration
+	refreshTTL    time.Duration
+	flatsCacheTTL time.Duration
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+func NewCache(lc fx.Lifecycle, cfg *config.Config) *Cache {
+	c := &Cache{}
+	c.Init(cfg.Redis.SessionTimeout, cfg.Redis.RefreshTimeout, cfg.Redis.FlatCacheTimeout)
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			c.Start()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			c.Stop()
+			return nil
+		},
+	})
+	return c
+}
+
+func (c *Cache) Init(sessionTimeout int, refreshTimeout int, flatCacheTimeout int) {
+	c.sessions = make(map[string]session)
+	c.userSessions = make(map[string]map[string]struct{})
+	c.refresh = make(map[string]*refreshToken)
+	c.families = make(map[string]map[string]struct{})
+	c.userFamilies = make(map[string]map[string]struct{})
+	c.revokedTokens = make(map[string]time.Time)
+	c.revokedUsers = make(map[string]revokedUser)
+	c.pages = make(map[string]cachedPage)
+	c.sessionTTL = time.Duration(sessionTimeout) * time.Minute
+	c.refreshTTL = time.Duration(refreshTimeout) * time.Minute
+	c.flatsCacheTTL = time.Duration(flatCacheTimeout) * time.Minute
+}
+
+// Start runs the sweep every sweepInterval until Stop is called.
+func (c *Cache) Start() {
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.sweep(time.Now())
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Cache) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+// sweep removes entries expired by now together with the set members that
+// point to them.
+func (c *Cache) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, s := range c.sessions {
+		if now.After(s.expiresAt) {
+			c.deleteSession(id)
+		}
+	}
+	for userId, ids := range c.userSessions {
+		if len(ids) == 0 {
+			delete(c.userSessions, userId)
+		}
+	}
+	for token, t := range c.refresh {
+		if now.After(t.expiresAt) {
+			delete(c.refresh, token)
+			delete(c.families[t.family], token)
+		}
+	}
+	for family, tokens := range c.families {
+		if len(tokens) == 0 {
+			delete(c.families, family)
+		}
+	}
+	for userId, families := range c.userFamilies {
+		for family := range families {
+			if _, ok := c.families[family]; !ok {
+				delete(families, family)
+			}
+		}
+		if len(families) == 0 {
+			delete(c.userFamilies, userId)
+		}
+	}
+	for jti, expiresAt := range c.revokedTokens {
+		if now.After(expiresAt) {
+			delete(c.revokedTokens, jti)
+		}
+	}
+	for userId, u := range c.revokedUsers {
+		if now.After(u.expiresAt) {
+			delete(c.revokedUsers, userId)
+		}
+	}
+	for cacheId, page := range c.pages {
+		if now.After(page.expiresAt) {
+			delete(c.pages, cacheId)
+		}
+	}
+}
+
+func addToSet(sets map[string]map[string]struct{}, key string, value string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]struct{})
+	}
+	sets[key][value] = struct{}{}
+}
+
+func (c *Cache) CreateSession(ctx context.Context, userId string, admin bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := uuid.New().String()
+	c.sessions[id] = session{userId: userId, admin: admin, expiresAt: time.Now().Add(c.sessionTTL)}
+	if userId != "" {
+		addToSet(c.userSessions, userId, id)
+	}
+	return id, nil
+}
+
+func (c *Cache) GetSession(ctx context.Context, id string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sessions[id]
+	if !ok {
+		return "", false, repositories.ErrSessionNotFound
+	}
+	if time.Now().After(s.expiresAt) {
+		c.deleteSession(id)
+		return "", false, repositories.ErrSessionNotFound
+	}
+	return s.userId, s.admin, nil
+}
+
+// deleteSession removes the session. The caller must hold mu.
+func (c *Cache) deleteSession(id string) {
+	s, ok := c.sessions[id]
+	if !ok {
+		return
+	}
+	delete(c.sessions, id)
+	if s.userId != "" {
+		delete(c.userSessions[s.userId], id)
+	}
+}
+
+func (c *Cache) DeleteSession(ctx context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteSession(id)
+	return nil
+}
+
+func (c *Cache) DeleteUserSessions(ctx context.Context, userId string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.userSessions[userId] {
+		delete(c.sessions, id)
+	}
+	delete(c.userSessions, userId)
+	return nil
+}
+
+func (c *Cache) CreateRefreshToken(ctx context.Context, userId string, admin bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.createRefreshToken(userId, admin, uuid.New().String()), nil
+}
+
+// createRefreshToken adds a token to the family. The caller must hold mu.
+func (c *Cache) createRefreshToken(userId string, admin bool, family string) string {
+	token := uuid.New().String()
+	c.refresh[token] = &refreshToken{
+		userId:    userId,
+		admin:     admin,
+		family:    family,
+		expiresAt: time.Now().Add(c.refreshTTL),
+	}
+	addToSet(c.families, family, token)
+	addToSet(c.userFamilies, userId, family)
+	return token
+}
+
+// RotateRefreshToken follows cache.Cache.RotateRefreshToken: a token is
+// single use, and reusing it revokes its family.
+func (c *Cache) RotateRefreshToken(ctx context.Context, token string) (userId string, admin bool, newToken string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.refresh[token]
+	if !ok || time.Now().After(t.expiresAt) {
+		return "", false, "", cache.ErrRefreshTokenInvalid
+	}
+	if t.used {
+		c.deleteRefreshFamily(t.family)
+		return "", false, "", cache.ErrRefreshTokenReused
+	}
+	t.used = true
+	return t.userId, t.admin, c.createRefreshToken(t.userId, t.admin, t.family), nil
+}
+
+// deleteRefreshFamily removes all tokens of the family. The caller must
+// hold mu.
+func (c *Cache) deleteRefreshFamily(family string) {
+	for token := range c.families[family] {
+		delete(c.refresh, token)
+	}
+	delete(c.families, family)
+}
+
+func (c *Cache) DeleteRefreshToken(ctx context.Context, token string, userId string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.refresh[token]
+	if !ok || time.Now().After(t.expiresAt) {
+		return nil
+	}
+	if t.userId != userId {
+		return cache.ErrRefreshTokenNotOwned
+	}
+	c.deleteRefreshFamily(t.family)
+	return nil
+}
+
+func (c *Cache) DeleteUserRefreshTokens(ctx context.Context, userId string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for family := range c.userFamilies[userId] {
+		c.deleteRefreshFamily(family)
+	}
+	delete(c.userFamilies, userId)
+	return nil
+}
+
+func (c *Cache) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+func (c *Cache) RevokeUserTokens(ctx context.Context, userId string, before time.Time, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revokedUsers[userId] = revokedUser{before: before, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// IsTokenRevoked follows cache.Cache.IsTokenRevoked, including the
+// microsecond precision of issue times.
+func (c *Cache) IsTokenRevoked(ctx context.Context, jti string, userId string, issuedAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if expiresAt, ok := c.revokedTokens[jti]; ok {
+		if now.Before(expiresAt) {
+			return true, nil
+		}
+		delete(c.revokedTokens, jti)
+	}
+	if userId == "" {
+		return false, nil
+	}
+	u, ok := c.revokedUsers[userId]
+	if !ok {
+		return false, nil
+	}
+	if now.After(u.expiresAt) {
+		delete(c.revokedUsers, userId)
+		return false, nil
+	}
+	return issuedAt.UnixMicro() <= u.before.UnixMicro(), nil
+}
+
+// Pages are stored as JSON, like in Redis, so a cached page looks exactly
+// like one read back from Redis and cannot be changed by the caller.
+
+func (c *Cache) GetFlatsCache(ctx context.Context, cacheId string) (*entities.FlatPage, error) {
+	c.mu.Lock()
+	page, ok := c.pages[cacheId]
+	if ok && time.Now().After(page.expiresAt) {
+		delete(c.pages, cacheId)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, repositories.ErrNotCached
+	}
+	var response entities.FlatPage
+	if err := json.Unmarshal(page.body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (c *Cache) PutFlatsCache(ctx context.Context, cacheId string, page *entities.FlatPage) error {
+	body, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pages[cacheId] = cachedPage{body: body, expiresAt: time.Now().Add(c.flatsCacheTTL)}
+	return nil
+}
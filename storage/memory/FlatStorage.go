@@ -0,0 +1,547 @@
+package memory
+
+import (
+	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/storages"
+	"context"
+	"sort"
+	"strconv"
+)
+
+func copyFlat(flat *entities.Flat) entities.Flat {
+	result := *flat
+	if flat.ClaimedAt != nil {
+		claimedAt := *flat.ClaimedAt
+		result.ClaimedAt = &claimedAt
+	}
+	if flat.DeclineReason != nil {
+		reason := *flat.DeclineReason
+		result.DeclineReason = &reason
+	}
+	if flat.MarketChanged != nil {
+		changed := *flat.MarketChanged
+		result.MarketChanged = &changed
+	}
+	return result
+}
+
+// getFlat returns the stored flat. The caller must hold mu.
+func (s *Storage) getFlat(flatId int, homeId int) (*entities.Flat, error) {
+	flat, ok := s.flats[flatKey{homeId, flatId}]
+	if !ok {
+		return nil, storages.ErrFlatNotFound
+	}
+	return flat, nil
+}
+
+func checkOwner(flat *entities.Flat, userId string, admin bool) error {
+	if admin || flat.CreatedBy != "" && flat.CreatedBy == userId {
+		return nil
+	}
+	return storages.ErrNotFlatOwner
+}
+
+func illegalTransition(err error) error {
+	return storages.ErrIllegalTransition.WithMessage(err.Error()).Wrap(err)
+}
+
+// addHistory records a moderation step. The caller must hold mu.
+func (s *Storage) addHistory(event entities.FlatModerationEvent) {
+	event.Id = int64(len(s.history) + 1)
+	s.history = append(s.history, event)
+}
+
+func (s *Storage) CreateFlat(ctx context.Context, flatId int, houseId int, price int, rooms int, createdBy string) (*entities.Flat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.liveHome(houseId); err != nil {
+		return nil, err
+	}
+	key := flatKey{houseId, flatId}
+	if _, ok := s.flats[key]; ok {
+		return nil, storages.ErrFlatExists
+	}
+	s.lastFlatId++
+	flat := entities.Flat{
+		Id:           s.lastFlatId,
+		Number:       flatId,
+		Price:        price,
+		Rooms:        rooms,
+		HomeId:       houseId,
+		Status:       string(entities.CREATED),
+		CreatedBy:    createdBy,
+		MarketStatus: string(entities.ACTIVE),
+	}
+	if err := s.addEvent(entities.FLAT_CREATED, entities.FlatEvent{Flat: flat}); err != nil {
+		return nil, err
+	}
+	s.flats[key] = &flat
+	s.touchHome(houseId, now())
+	result := copyFlat(&flat)
+	return &result, nil
+}
+
+func (s *Storage) ImportFlats(ctx context.Context, houseId int, flats []entities.Flat, createdBy string) ([]entities.Flat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.liveHome(houseId); err != nil {
+		return nil, err
+	}
+	numbers := make(map[int]struct{}, len(flats))
+	for _, flat := range flats {
+		if _, ok := s.flats[flatKey{houseId, flat.Number}]; ok {
+			return nil, storages.ErrFlatExists
+		}
+		if _, ok := numbers[flat.Number]; ok {
+			return nil, storages.ErrFlatExists
+		}
+		numbers[flat.Number] = struct{}{}
+	}
+	result := make([]entities.Flat, 0, len(flats))
+	for _, imported := range flats {
+		s.lastFlatId++
+		flat := entities.Flat{
+			Id:           s.lastFlatId,
+			Number:       imported.Number,
+			Price:        imported.Price,
+			Rooms:        imported.Rooms,
+			HomeId:       houseId,
+			Status:       string(entities.CREATED),
+			CreatedBy:    createdBy,
+			MarketStatus: string(entities.ACTIVE),
+		}
+		if err := s.addEvent(entities.FLAT_CREATED, entities.FlatEvent{Flat: flat}); err != nil {
+			return nil, err
+		}
+		s.flats[flatKey{houseId, flat.Number}] = &flat
+		result = append(result, copyFlat(&flat))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Number < result[j].Number
+	})
+	s.touchHome(houseId, now())
+	return result, nil
+}
+
+func (s *Storage) UpdateFlat(
+	ctx context.Context,
+	flatId int,
+	homeId int,
+	price int,
+	rooms int,
+	status entities.ModerationStatus,
+	declineReason *entities.DeclineReason,
+	moderatorId string) (*entities.Flat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, err := s.getFlat(flatId, homeId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := now()
+	if err := entities.ValidateTransition(entities.ModerationStatus(previous.Status), status); err != nil {
+		return nil, illegalTransition(err)
+	}
+	claimActive := previous.Status == string(entities.ON_MODERATION) &&
+		previous.ModeratorId != "" &&
+		previous.ClaimedAt != nil &&
+		previous.ClaimedAt.After(now.Add(-s.claimTimeout))
+	if claimActive && previous.ModeratorId != moderatorId {
+		return nil, storages.ErrFlatClaimed
+	}
+	decision := string(status) != previous.Status
+	if (status == entities.APPROVED || status == entities.DECLINED) && decision && !claimActive {
+		return nil, storages.ErrFlatNotClaimed
+	}
+
+	flat := copyFlat(previous)
+	flat.Price = price
+	flat.Rooms = rooms
+	flat.Status = string(status)
+	flat.ModeratorId = ""
+	flat.ClaimedAt = nil
+	if status == entities.ON_MODERATION {
+		flat.ModeratorId = moderatorId
+		flat.ClaimedAt = &now
+	}
+	reason := ""
+	if status == entities.DECLINED {
+		flat.DeclineReason = declineReason
+		if declineReason != nil {
+			reason = declineReason.Code + ": " + declineReason.Text
+		}
+	} else if status != entities.ModerationStatus(previous.Status) {
+		flat.DeclineReason = nil
+	}
+
+	if previous.Status != flat.Status {
+		err = s.addEvent(entities.FLAT_STATUS_CHANGED, entities.FlatEvent{
+			Flat:           flat,
+			PreviousStatus: previous.Status,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.addHistory(entities.FlatModerationEvent{
+		HomeId:         homeId,
+		FlatNumber:     flatId,
+		ActorId:        moderatorId,
+		PreviousStatus: previous.Status,
+		NewStatus:      flat.Status,
+		PreviousPrice:  previous.Price,
+		NewPrice:       price,
+		PreviousRooms:  previous.Rooms,
+		NewRooms:       rooms,
+		Reason:         reason,
+		CreatedAt:      now,
+	})
+	*previous = flat
+	s.touchHome(homeId, now)
+	result := copyFlat(&flat)
+	return &result, nil
+}
+
+func (s *Storage) ClaimFlat(ctx context.Context, flatId int, homeId int, moderatorId string) (*entities.Flat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, err := s.getFlat(flatId, homeId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := now()
+	if _, err := entities.CLAIM.Apply(entities.ModerationStatus(previous.Status)); err != nil {
+		return nil, illegalTransition(err)
+	}
+	claimable := previous.Status == string(entities.CREATED) ||
+		previous.ModeratorId == "" ||
+		previous.ModeratorId == moderatorId ||
+		previous.ClaimedAt == nil ||
+		!previous.ClaimedAt.After(now.Add(-s.claimTimeout))
+	if !claimable {
+		return nil, storages.ErrFlatClaimed
+	}
+
+	flat := copyFlat(previous)
+	flat.Status = string(entities.ON_MODERATION)
+	flat.ModeratorId = moderatorId
+	flat.ClaimedAt = &now
+	if previous.Status != flat.Status {
+		err = s.addEvent(entities.FLAT_STATUS_CHANGED, entities.FlatEvent{
+			Flat:           flat,
+			PreviousStatus: previous.Status,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.addHistory(entities.FlatModerationEvent{
+		HomeId:         homeId,
+		FlatNumber:     flatId,
+		ActorId:        moderatorId,
+		PreviousStatus: previous.Status,
+		NewStatus:      flat.Status,
+		PreviousPrice:  flat.Price,
+		NewPrice:       flat.Price,
+		PreviousRooms:  flat.Rooms,
+		NewRooms:       flat.Rooms,
+		Reason:         "claimed for review",
+		CreatedAt:      now,
+	})
+	*previous = flat
+	result := copyFlat(&flat)
+	return &result, nil
+}
+
+func (s *Storage) ResubmitFlat(ctx context.Context, flatId int, homeId int, price int, rooms int, userId string, admin bool) (*entities.Flat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, err := s.getFlat(flatId, homeId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOwner(previous, userId, admin); err != nil {
+		return nil, err
+	}
+	status, err := entities.RESUBMIT.Apply(entities.ModerationStatus(previous.Status))
+	if err != nil {
+		return nil, illegalTransition(err)
+	}
+	if previous.Resubmissions >= s.maxResubmissions {
+		return nil, storages.ErrResubmissionsReached
+	}
+
+	now := now()
+	flat := copyFlat(previous)
+	flat.Price = price
+	flat.Rooms = rooms
+	flat.Status = string(status)
+	flat.ModeratorId = ""
+	flat.ClaimedAt = nil
+	flat.DeclineReason = nil
+	flat.Resubmissions++
+	err = s.addEvent(entities.FLAT_STATUS_CHANGED, entities.FlatEvent{
+		Flat:           flat,
+		PreviousStatus: previous.Status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.addHistory(entities.FlatModerationEvent{
+		HomeId:         homeId,
+		FlatNumber:     flatId,
+		ActorId:        userId,
+		PreviousStatus: previous.Status,
+		NewStatus:      flat.Status,
+		PreviousPrice:  previous.Price,
+		NewPrice:       price,
+		PreviousRooms:  previous.Rooms,
+		NewRooms:       rooms,
+		Reason:         "resubmitted",
+		CreatedAt:      now,
+	})
+	*previous = flat
+	s.touchHome(homeId, now)
+	result := copyFlat(&flat)
+	return &result, nil
+}
+
+func (s *Storage) SetMarketStatus(
+	ctx context.Context,
+	flatId int,
+	homeId int,
+	status entities.MarketStatus,
+	userId string,
+	admin bool) (*entities.Flat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flat, err := s.getFlat(flatId, homeId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOwner(flat, userId, admin); err != nil {
+		return nil, err
+	}
+	if flat.MarketStatus != string(entities.ACTIVE) {
+		return nil, storages.ErrFlatOffMarket
+	}
+	now := now()
+	flat.MarketStatus = string(status)
+	flat.MarketChanged = &now
+	s.touchHome(homeId, now)
+	result := copyFlat(flat)
+	return &result, nil
+}
+
+func (s *Storage) StartModeration(ctx context.Context, homeId int, moderatorId string) ([]entities.Flat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.liveHome(homeId); err != nil {
+		return nil, err
+	}
+
+	now := now()
+	changed := make([]entities.Flat, 0)
+	for key, flat := range s.flats {
+		if key.homeId != homeId || flat.Status != string(entities.CREATED) {
+			continue
+		}
+		next := copyFlat(flat)
+		next.Status = string(entities.ON_MODERATION)
+		err := s.addEvent(entities.FLAT_STATUS_CHANGED, entities.FlatEvent{
+			Flat:           next,
+			PreviousStatus: flat.Status,
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.addHistory(entities.FlatModerationEvent{
+			HomeId:         homeId,
+			FlatNumber:     flat.Number,
+			ActorId:        moderatorId,
+			PreviousStatus: flat.Status,
+			NewStatus:      next.Status,
+			PreviousPrice:  flat.Price,
+			NewPrice:       flat.Price,
+			PreviousRooms:  flat.Rooms,
+			NewRooms:       flat.Rooms,
+			Reason:         "moderation of the house started",
+			CreatedAt:      now,
+		})
+		*flat = next
+		changed = append(changed, copyFlat(flat))
+	}
+	if len(changed) > 0 {
+		s.touchHome(homeId, now)
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Id < changed[j].Id })
+	return changed, nil
+}
+
+func (s *Storage) GetFlat(ctx context.Context, flatId int, homeId int) (*entities.Flat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	flat, err := s.getFlat(flatId, homeId)
+	if err != nil {
+		return nil, err
+	}
+	result := copyFlat(flat)
+	return &result, nil
+}
+
+func (s *Storage) GetUserFlats(ctx context.Context, userId string) ([]entities.Flat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]entities.Flat, 0)
+	for key, flat := range s.flats {
+		if flat.CreatedBy != userId {
+			continue
+		}
+		if _, err := s.liveHome(key.homeId); err != nil {
+			continue
+		}
+		result = append(result, copyFlat(flat))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].HomeId != result[j].HomeId {
+			return result[i].HomeId < result[j].HomeId
+		}
+		return result[i].Number < result[j].Number
+	})
+	return result, nil
+}
+
+func (s *Storage) FilterFlats(ctx context.Context, homeId int, admin bool, filter entities.FlatFilter) (*entities.FlatPage, error) {
+	filter.HouseIds = []int{homeId}
+	filter.Statuses = nil
+	filter.MarketStatuses = []string{string(entities.ACTIVE)}
+	filter.IncludeDeleted = admin
+	if !admin {
+		filter.Statuses = []string{string(entities.APPROVED)}
+	}
+	return s.SearchFlats(ctx, filter)
+}
+
+// flatSortValues are the sort columns of flat listings, see
+// storages.FlatStorage.
+var flatSortValues = map[string]func(flat *entities.Flat) int64{
+	"price":   func(flat *entities.Flat) int64 { return int64(flat.Price) },
+	"rooms":   func(flat *entities.Flat) int64 { return int64(flat.Rooms) },
+	"number":  func(flat *entities.Flat) int64 { return int64(flat.Number) },
+	"created": func(flat *entities.Flat) int64 { return flat.Id },
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHome checks the house constraints of the filter. The caller must
+// hold mu.
+func (s *Storage) matchesHome(homeId int, filter *entities.FlatFilter) bool {
+	home, ok := s.homes[homeId]
+	if !ok {
+		return false
+	}
+	return (filter.IncludeDeleted || home.DeletedAt == nil) &&
+		(filter.Developer == "" || home.Developer == filter.Developer) &&
+		(filter.YearFrom <= 0 || home.Year >= filter.YearFrom) &&
+		(filter.YearTo <= 0 || home.Year <= filter.YearTo)
+}
+
+func (s *Storage) SearchFlats(ctx context.Context, filter entities.FlatFilter) (*entities.FlatPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := flatSortValues[filter.Sort]
+	if !ok {
+		filter.Sort = "created"
+		value = flatSortValues["created"]
+	}
+	direction := "ASC"
+	if filter.Desc {
+		direction = "DESC"
+	}
+	sortKey := filter.Sort + ":" + direction
+	compare := func(aValue int64, aId int64, bValue int64, bId int64) int {
+		result := compareInt(aValue, bValue)
+		if result == 0 {
+			result = compareInt(aId, bId)
+		}
+		if filter.Desc {
+			result = -result
+		}
+		return result
+	}
+
+	var after *storages.Cursor
+	var afterValue int64
+	if filter.Cursor != "" {
+		c, err := storages.DecodeCursor(filter.Cursor, sortKey)
+		if err != nil {
+			return nil, err
+		}
+		afterValue, err = strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, storages.ErrInvalidCursor.Wrap(err)
+		}
+		after = c
+	}
+
+	flats := make([]entities.Flat, 0)
+	for key, flat := range s.flats {
+		switch {
+		case len(filter.Statuses) > 0 && !containsString(filter.Statuses, flat.Status):
+		case len(filter.MarketStatuses) > 0 && !containsString(filter.MarketStatuses, flat.MarketStatus):
+		case filter.PriceMin > 0 && flat.Price < filter.PriceMin:
+		case filter.PriceMax > 0 && flat.Price > filter.PriceMax:
+		case len(filter.Rooms) > 0 && !containsInt(filter.Rooms, flat.Rooms):
+		case len(filter.HouseIds) > 0 && !containsInt(filter.HouseIds, key.homeId):
+		case !s.matchesHome(key.homeId, &filter):
+		case after != nil && compare(value(flat), flat.Id, afterValue, after.Id) <= 0:
+		default:
+			flats = append(flats, copyFlat(flat))
+		}
+	}
+	sort.Slice(flats, func(i, j int) bool {
+		return compare(value(&flats[i]), flats[i].Id, value(&flats[j]), flats[j].Id) < 0
+	})
+
+	page := entities.FlatPage{Flats: flats}
+	if len(flats) > filter.Limit {
+		page.Flats = flats[:filter.Limit]
+		last := &page.Flats[filter.Limit-1]
+		page.NextCursor = storages.Cursor{Sort: sortKey, Value: strconv.FormatInt(value(last), 10), Id: last.Id}.Encode()
+	}
+	return &page, nil
+}
+
+func (s *Storage) GetFlatHistory(ctx context.Context, homeId int, flatId int) ([]entities.FlatModerationEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, err := s.getFlat(flatId, homeId); err != nil {
+		return nil, err
+	}
+	result := make([]entities.FlatModerationEvent, 0)
+	for _, event := range s.history {
+		if event.HomeId == homeId && event.FlatNumber == flatId {
+			result = append(result, event)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,291 @@
+package memory
+
+import (
+	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/storages"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func copyHome(home *entities.Home) entities.Home {
+	result := *home
+	if home.DeletedAt != nil {
+		deletedAt := *home.DeletedAt
+		result.DeletedAt = &deletedAt
+	}
+	return result
+}
+
+// liveHome returns the house unless it does not exist or is deleted.
+// The caller must hold mu.
+func (s *Storage) liveHome(homeId int) (*entities.Home, error) {
+	home, ok := s.homes[homeId]
+	if !ok || home.DeletedAt != nil {
+		return nil, storages.ErrHouseNotFound
+	}
+	return home, nil
+}
+
+// touchHome bumps updated_at of the house. The caller must hold mu.
+func (s *Storage) touchHome(homeId int, at time.Time) {
+	if home, ok := s.homes[homeId]; ok {
+		home.UpdatedAt = at
+	}
+}
+
+func (s *Storage) CreateHome(ctx context.Context, address string, year int, developer string, reviewer string) (*entities.Home, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHomeId++
+	creationTime := now()
+	home := entities.Home{
+		Id:        s.lastHomeId,
+		Address:   address,
+		Year:      year,
+		Developer: developer,
+		Reviewer:  reviewer,
+		CreatedAt: creationTime,
+		UpdatedAt: creationTime,
+	}
+	s.homes[home.Id] = &home
+	result := copyHome(&home)
+	return &result, nil
+}
+
+func (s *Storage) UpdateHome(
+	ctx context.Context,
+	homeId int,
+	address *string,
+	year *int,
+	developer *string,
+	expectedUpdatedAt time.Time) (*entities.Home, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	home, err := s.liveHome(homeId)
+	if err != nil {
+		return nil, err
+	}
+	if !home.UpdatedAt.Equal(expectedUpdatedAt) {
+		return nil, storages.ErrHouseModified
+	}
+	if address != nil {
+		home.Address = *address
+	}
+	if year != nil {
+		home.Year = *year
+	}
+	if developer != nil {
+		home.Developer = *developer
+	}
+	home.UpdatedAt = now()
+	result := copyHome(home)
+	return &result, nil
+}
+
+func (s *Storage) DeleteHome(ctx context.Context, homeId int) (*entities.Home, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	home, err := s.liveHome(homeId)
+	if err != nil {
+		return nil, err
+	}
+	deletedAt := now()
+	home.DeletedAt = &deletedAt
+	home.UpdatedAt = deletedAt
+	result := copyHome(home)
+	return &result, nil
+}
+
+func (s *Storage) RestoreHome(ctx context.Context, homeId int) (*entities.Home, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	home, ok := s.homes[homeId]
+	if !ok {
+		return nil, storages.ErrHouseNotFound
+	}
+	if home.DeletedAt == nil {
+		return nil, storages.ErrHouseNotDeleted
+	}
+	home.DeletedAt = nil
+	home.UpdatedAt = now()
+	result := copyHome(home)
+	return &result, nil
+}
+
+// homeOrders compares houses by the sort columns of GET /houses.
+var homeOrders = map[string]func(a *entities.Home, b *entities.Home) int{
+	"id":         func(a, b *entities.Home) int { return compareInt(int64(a.Id), int64(b.Id)) },
+	"address":    func(a, b *entities.Home) int { return strings.Compare(a.Address, b.Address) },
+	"year":       func(a, b *entities.Home) int { return compareInt(int64(a.Year), int64(b.Year)) },
+	"created_at": func(a, b *entities.Home) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	"updated_at": func(a, b *entities.Home) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+}
+
+func compareInt(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// homeSortValue is the value of the sort column written into cursors, in the
+// same format as storages.HomeStorage uses.
+func homeSortValue(home *entities.Home, column string) string {
+	switch column {
+	case "address":
+		return home.Address
+	case "year":
+		return strconv.Itoa(home.Year)
+	case "created_at":
+		return home.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		return home.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		return strconv.Itoa(home.Id)
+	}
+}
+
+// cursorHome rebuilds the last house of the previous page from the cursor.
+func cursorHome(c *storages.Cursor, column string) (*entities.Home, error) {
+	home := entities.Home{Id: int(c.Id)}
+	var err error
+	switch column {
+	case "address":
+		home.Address = c.Value
+	case "year":
+		home.Year, err = strconv.Atoi(c.Value)
+	case "created_at":
+		home.CreatedAt, err = time.Parse(time.RFC3339Nano, c.Value)
+	case "updated_at":
+		home.UpdatedAt, err = time.Parse(time.RFC3339Nano, c.Value)
+	}
+	if err != nil {
+		return nil, storages.ErrInvalidCursor.Wrap(err)
+	}
+	return &home, nil
+}
+
+// hasApprovedFlats tells whether the house has an approved flat still on
+// the market. The caller must hold mu.
+func (s *Storage) hasApprovedFlats(homeId int) bool {
+	for key, flat := range s.flats {
+		if key.homeId == homeId && flat.Status == string(entities.APPROVED) && flat.MarketStatus == string(entities.ACTIVE) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) SearchHomes(ctx context.Context, filter entities.HomeFilter) (*entities.HomePage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := homeOrders[filter.Sort]
+	if !ok {
+		filter.Sort = "id"
+		order = homeOrders["id"]
+	}
+	direction := "ASC"
+	if filter.Desc {
+		direction = "DESC"
+	}
+	sortKey := filter.Sort + ":" + direction
+	compare := func(a, b *entities.Home) int {
+		result := order(a, b)
+		if result == 0 {
+			result = compareInt(int64(a.Id), int64(b.Id))
+		}
+		if filter.Desc {
+			result = -result
+		}
+		return result
+	}
+
+	var after *entities.Home
+	if filter.Cursor != "" {
+		c, err := storages.DecodeCursor(filter.Cursor, sortKey)
+		if err != nil {
+			return nil, err
+		}
+		if after, err = cursorHome(c, filter.Sort); err != nil {
+			return nil, err
+		}
+	}
+
+	address := strings.ToLower(filter.Address)
+	homes := make([]entities.Home, 0)
+	for _, home := range s.homes {
+		switch {
+		case home.DeletedAt != nil && !filter.IncludeDeleted:
+		case address != "" && !strings.Contains(strings.ToLower(home.Address), address):
+		case filter.Developer != "" && home.Developer != filter.Developer:
+		case filter.YearFrom > 0 && home.Year < filter.YearFrom:
+		case filter.YearTo > 0 && home.Year > filter.YearTo:
+		case filter.HasApprovedFlats && !s.hasApprovedFlats(home.Id):
+		case after != nil && compare(home, after) <= 0:
+		default:
+			homes = append(homes, copyHome(home))
+		}
+	}
+	sort.Slice(homes, func(i, j int) bool {
+		return compare(&homes[i], &homes[j]) < 0
+	})
+
+	page := entities.HomePage{Homes: homes}
+	if len(homes) > filter.Limit {
+		page.Homes = homes[:filter.Limit]
+		last := &page.Homes[filter.Limit-1]
+		page.NextCursor = storages.Cursor{Sort: sortKey, Value: homeSortValue(last, filter.Sort), Id: int64(last.Id)}.Encode()
+	}
+	return &page, nil
+}
+
+func (s *Storage) GetLastHomeUpdate(ctx context.Context, homeId int) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	home, err := s.liveHome(homeId)
+	if err != nil {
+		return time.Unix(0, 0), err
+	}
+	return home.UpdatedAt, nil
+}
+
+func (s *Storage) GetHomeReviewer(ctx context.Context, homeId int) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	home, err := s.liveHome(homeId)
+	if err != nil {
+		return "", err
+	}
+	return home.Reviewer, nil
+}
+
+func (s *Storage) CreateSubscription(ctx context.Context, homeId int, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.liveHome(homeId); err != nil {
+		return err
+	}
+	for _, subscriber := range s.subscriptions[homeId] {
+		if subscriber == email {
+			return nil
+		}
+	}
+	s.subscriptions[homeId] = append(s.subscriptions[homeId], email)
+	return nil
+}
+
+func (s *Storage) GetSubscribers(ctx context.Context, homeId int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]string, len(s.subscriptions[homeId]))
+	copy(result, s.subscriptions[homeId])
+	return result, nil
+}
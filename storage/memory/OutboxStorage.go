@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type outboxEntry struct {
+	event       entities.OutboxEvent
+	deliveredAt *time.Time
+}
+
+// addEvent appends an event to the outbox. The caller must hold mu, so the
+// event is published together with the change it describes.
+func (s *Storage) addEvent(eventType entities.EventType, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	s.lastEventId++
+	s.outbox = append(s.outbox, &outboxEntry{event: entities.OutboxEvent{
+		Id:        s.lastEventId,
+		Type:      eventType,
+		Payload:   body,
+		CreatedAt: now(),
+	}})
+	return nil
+}
+
+// DispatchOutbox follows storages.OutboxStorage.Dispatch: up to batchSize
+// undelivered events are passed to handle, failed ones are retried on the
+// next call until maxAttempts is reached.
+func (s *Storage) DispatchOutbox(
+	ctx context.Context,
+	batchSize int,
+	maxAttempts int,
+	handle func(context.Context, entities.OutboxEvent) error) (int, error) {
+	s.dispatchMu.Lock()
+	defer s.dispatchMu.Unlock()
+
+	s.mu.RLock()
+	batch := make([]*outboxEntry, 0, batchSize)
+	for _, entry := range s.outbox {
+		if len(batch) == batchSize {
+			break
+		}
+		if entry.deliveredAt == nil && entry.event.Attempts < maxAttempts {
+			batch = append(batch, entry)
+		}
+	}
+	events := make([]entities.OutboxEvent, 0, len(batch))
+	for _, entry := range batch {
+		events = append(events, entry.event)
+	}
+	s.mu.RUnlock()
+
+	results := make([]error, len(events))
+	for i, event := range events {
+		results[i] = handle(ctx, event)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivered := 0
+	for i, entry := range batch {
+		entry.event.Attempts++
+		if results[i] == nil {
+			deliveredAt := now()
+			entry.deliveredAt = &deliveredAt
+			delivered++
+		}
+	}
+	return delivered, nil
+}
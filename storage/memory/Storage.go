@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"bootcamp_task/config"
+	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/repositories"
+	"sync"
+	"time"
+)
+
+var (
+	_ repositories.HouseRepository  = (*Storage)(nil)
+	_ repositories.FlatRepository   = (*Storage)(nil)
+	_ repositories.UserRepository   = (*Storage)(nil)
+	_ repositories.OutboxRepository = (*Storage)(nil)
+)
+
+type flatKey struct {
+	homeId int
+	number int
+}
+
+// Storage keeps all data of storages.Storage in process memory and follows
+// the same rules, so the API behaves the same without Postgres. All methods
+// are safe for concurrent use; each of them is atomic like a transaction.
+type Storage struct {
+	mu sync.RWMutex
+
+	users         map[string]*entities.User
+	homes         map[int]*entities.Home
+	flats         map[flatKey]*entities.Flat
+	history       []entities.FlatModerationEvent
+	subscriptions map[int][]string
+	outbox        []*outboxEntry
+
+	lastHomeId  int
+	lastFlatId  int64
+	lastEventId int64
+
+	// dispatchMu serializes DispatchOutbox, handlers run without mu.
+	dispatchMu sync.Mutex
+
+	claimTimeout     time.Duration
+	maxResubmissions int
+}
+
+func NewStorage(cfg *config.Config) *Storage {
+	s := Storage{}
+	s.Init(cfg.Moderation.ClaimTimeout, cfg.Moderation.MaxResubmissions)
+	return &s
+}
+
+func (s *Storage) Init(claimTimeout float64, maxResubmissions int) {
+	s.users = make(map[string]*entities.User)
+	s.homes = make(map[int]*entities.Home)
+	s.flats = make(map[flatKey]*entities.Flat)
+	s.history = make([]entities.FlatModerationEvent, 0)
+	s.subscriptions = make(map[int][]string)
+	s.outbox = make([]*outboxEntry, 0)
+	s.claimTimeout = time.Duration(claimTimeout * float64(time.Minute))
+	if s.claimTimeout <= 0 {
+		s.claimTimeout = 30 * time.Minute
+	}
+	s.maxResubmissions = maxResubmissions
+	if s.maxResubmissions <= 0 {
+		s.maxResubmissions = 3
+	}
+}
+
+// now returns the current time with the precision of Postgres timestamps,
+// so values read back by clients compare equal in UpdateHome.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/storages"
+	"context"
+
+	"github.com/google/uuid"
+)
+
+func (s *Storage) CreateUser(ctx context.Context, email string, password string, isAdmin bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[email]; ok {
+		return "", storages.ErrUserExists
+	}
+	user := entities.User{
+		Id:       uuid.New().String(),
+		Email:    email,
+		Password: password,
+		IsAdmin:  isAdmin,
+	}
+	s.users[email] = &user
+	return user.Id, nil
+}
+
+func (s *Storage) GetUser(ctx context.Context, email string) (*entities.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[email]
+	if !ok {
+		return nil, storages.ErrUserNotFound
+	}
+	result := *user
+	return &result, nil
+}
+
+func (s *Storage) UpdateUserPassword(ctx context.Context, id string, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Id == id {
+			user.Password = password
+		}
+	}
+	return nil
+}
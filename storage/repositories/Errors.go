@@ -0,0 +1,10 @@
+package repositories
+
+import "errors"
+
+var (
+	// ErrSessionNotFound is returned for unknown or expired sessions.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrNotCached is returned by FlatsCache on a cache miss.
+	ErrNotCached = errors.New("not cached")
+)
@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+)
+
+// FlatRepository stores flats and their moderation history. Every change of
+// a flat bumps updated_at of its house, except ClaimFlat: a claim does not
+// change what clients see, and bumping would fail concurrent house updates
+// with ErrHouseModified. Changes of the moderation status are recorded in
+// the history and published to the outbox; SetMarketStatus does not change
+// it and writes neither.
+type FlatRepository interface {
+	CreateFlat(ctx context.Context, flatId int, houseId int, price int, rooms int, createdBy string) (*entities.Flat, error)
+	// ImportFlats creates Number, Price and Rooms of flats in the house as
+	// CreateFlat does, all or none of them. It returns ErrFlatExists if a
+	// number is taken.
+	ImportFlats(ctx context.Context, houseId int, flats []entities.Flat, createdBy string) ([]entities.Flat, error)
+	UpdateFlat(
+		ctx context.Context,
+		flatId int,
+		homeId int,
+		price int,
+		rooms int,
+		status entities.ModerationStatus,
+		declineReason *entities.DeclineReason,
+		moderatorId string) (*entities.Flat, error)
+	ClaimFlat(ctx context.Context, flatId int, homeId int, moderatorId string) (*entities.Flat, error)
+	ResubmitFlat(ctx context.Context, flatId int, homeId int, price int, rooms int, userId string, admin bool) (*entities.Flat, error)
+	SetMarketStatus(ctx context.Context, flatId int, homeId int, status entities.MarketStatus, userId string, admin bool) (*entities.Flat, error)
+	StartModeration(ctx context.Context, homeId int, moderatorId string) ([]entities.Flat, error)
+	GetFlat(ctx context.Context, flatId int, homeId int) (*entities.Flat, error)
+	GetUserFlats(ctx context.Context, userId string) ([]entities.Flat, error)
+	FilterFlats(ctx context.Context, homeId int, admin bool, filter entities.FlatFilter) (*entities.FlatPage, error)
+	SearchFlats(ctx context.Context, filter entities.FlatFilter) (*entities.FlatPage, error)
+	GetFlatHistory(ctx context.Context, homeId int, flatId int) ([]entities.FlatModerationEvent, error)
+}
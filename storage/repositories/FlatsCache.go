@@ -0,0 +1,14 @@
+package repositories
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+)
+
+// FlatsCache keeps pages of flats shown to clients. Keys include the house
+// version, so entries never need to be invalidated explicitly.
+type FlatsCache interface {
+	// GetFlatsCache returns ErrNotCached on a cache miss.
+	GetFlatsCache(ctx context.Context, cacheId string) (*entities.FlatPage, error)
+	PutFlatsCache(ctx context.Context, cacheId string, page *entities.FlatPage) error
+}
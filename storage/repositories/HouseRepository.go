@@ -0,0 +1,22 @@
+package repositories
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+	"time"
+)
+
+// HouseRepository stores houses and subscriptions to their new flats.
+// Deleted houses are reported as ErrHouseNotFound by every method except
+// SearchHomes with IncludeDeleted and RestoreHome.
+type HouseRepository interface {
+	CreateHome(ctx context.Context, address string, year int, developer string, reviewer string) (*entities.Home, error)
+	UpdateHome(ctx context.Context, homeId int, address *string, year *int, developer *string, expectedUpdatedAt time.Time) (*entities.Home, error)
+	DeleteHome(ctx context.Context, homeId int) (*entities.Home, error)
+	RestoreHome(ctx context.Context, homeId int) (*entities.Home, error)
+	SearchHomes(ctx context.Context, filter entities.HomeFilter) (*entities.HomePage, error)
+	GetLastHomeUpdate(ctx context.Context, homeId int) (time.Time, error)
+	GetHomeReviewer(ctx context.Context, homeId int) (string, error)
+	CreateSubscription(ctx context.Context, homeId int, email string) error
+	GetSubscribers(ctx context.Context, homeId int) ([]string, error)
+}
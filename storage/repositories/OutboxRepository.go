@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+)
+
+// OutboxRepository hands events written together with flat changes to the
+// dispatcher. See storages.OutboxStorage.Dispatch for the delivery rules.
+type OutboxRepository interface {
+	DispatchOutbox(
+		ctx context.Context,
+		batchSize int,
+		maxAttempts int,
+		handle func(context.Context, entities.OutboxEvent) error) (int, error)
+}
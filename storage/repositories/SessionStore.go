@@ -0,0 +1,33 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// SessionStore keeps login sessions, single-use refresh tokens and the
+// denylist of revoked stateless access tokens.
+type SessionStore interface {
+	CreateSession(ctx context.Context, userId string, admin bool) (string, error)
+	// GetSession returns ErrSessionNotFound for unknown or expired sessions.
+	GetSession(ctx context.Context, id string) (string, bool, error)
+	DeleteSession(ctx context.Context, id string) error
+	DeleteUserSessions(ctx context.Context, userId string) error
+
+	CreateRefreshToken(ctx context.Context, userId string, admin bool) (string, error)
+	RotateRefreshToken(ctx context.Context, token string) (userId string, admin bool, newToken string, err error)
+	// DeleteRefreshToken revokes the family of token if it was issued to
+	// userId and returns ErrRefreshTokenNotOwned otherwise. Unknown tokens
+	// are ignored.
+	DeleteRefreshToken(ctx context.Context, token string, userId string) error
+	DeleteUserRefreshTokens(ctx context.Context, userId string) error
+
+	// RevokeToken denies the access token with id jti until it expires.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeUserTokens denies every access token of the user issued not
+	// later than before. The mark is kept for ttl, the lifetime of a token.
+	RevokeUserTokens(ctx context.Context, userId string, before time.Time, ttl time.Duration) error
+	// IsTokenRevoked reports whether the token was denied by RevokeToken or
+	// RevokeUserTokens. Tokens without a user are checked by jti only.
+	IsTokenRevoked(ctx context.Context, jti string, userId string, issuedAt time.Time) (bool, error)
+}
@@ -0,0 +1,12 @@
+package repositories
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+)
+
+type UserRepository interface {
+	CreateUser(ctx context.Context, email string, password string, isAdmin bool) (string, error)
+	GetUser(ctx context.Context, email string) (*entities.User, error)
+	UpdateUserPassword(ctx context.Context, id string, password string) error
+}
@@ -0,0 +1,46 @@
+package storages
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// Cursor points right after the last row of a page in keyset pagination.
+// Sort is kept to reject a cursor reused with a different ordering.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    int64  `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	body, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+func DecodeCursor(value string, sort string) (*Cursor, error) {
+	body, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor.Wrap(err)
+	}
+	var c Cursor
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, ErrInvalidCursor.Wrap(err)
+	}
+	if c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// escapeLike escapes wildcards of a LIKE pattern.
+func escapeLike(value string) string {
+	result := make([]rune, 0, len(value))
+	for _, r := range value {
+		if r == '\\' || r == '%' || r == '_' {
+			result = append(result, '\\')
+		}
+		result = append(result, r)
+	}
+	return string(result)
+}
@@ -0,0 +1,63 @@
+package storages
+
+import (
+	"bootcamp_task/storage/errs"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrUserNotFound         = errs.NotFound("user_not_found", "user not found")
+	ErrUserExists           = errs.Conflict("user_exists", "user with same email already exists")
+	ErrHouseNotFound        = errs.NotFound("house_not_found", "house with specified id not found")
+	ErrFlatNotFound         = errs.NotFound("flat_not_found", "flat not found")
+	ErrFlatExists           = errs.Conflict("flat_exists", "flat with specified id already exists in this house")
+	ErrFlatClaimed          = errs.Conflict("flat_claimed", "flat is claimed by another moderator")
+	ErrFlatNotClaimed       = errs.Forbidden("flat_not_claimed", "flat must be claimed by the moderator before review")
+	ErrNotFlatOwner         = errs.Forbidden("not_flat_owner", "only the creator of the flat or a moderator can do this")
+	ErrResubmissionsReached = errs.Conflict("resubmission_limit_reached", "flat was resubmitted too many times")
+	ErrIllegalTransition    = errs.Conflict("illegal_status_transition", "illegal flat status transition")
+	ErrFlatOffMarket        = errs.Conflict("flat_off_market", "flat is already sold, rented or withdrawn")
+	ErrHouseModified        = errs.Conflict("house_modified", "house was modified by someone else, reload it and retry")
+	ErrHouseNotDeleted      = errs.Conflict("house_not_deleted", "house is not deleted")
+	ErrInvalidCursor        = errs.Validation("invalid_cursor", "cursor is malformed or was issued for another sort order")
+)
+
+const (
+	uniqueViolation      = "23505"
+	foreignKeyViolation  = "23503"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}
+
+// isRetryable tells whether the transaction was aborted by a conflict with
+// a concurrent one and succeeds if run again.
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected)
+}
+
+// notFound replaces pgx.ErrNoRows with the domain error.
+func notFound(err error, domainErr *errs.Error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domainErr
+	}
+	return err
+}
+
+// illegalTransition converts an error of the moderation state machine.
+func illegalTransition(err error) error {
+	return ErrIllegalTransition.WithMessage(err.Error()).Wrap(err)
+}
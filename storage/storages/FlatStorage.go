@@ -3,60 +3,200 @@ package storages
 import (
 	"bootcamp_task/storage/entities"
 	"context"
-	"database/sql"
-	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type FlatStorage struct {
+	outbox  OutboxStorage
+	history ModerationHistoryStorage
 }
 
-func (f FlatStorage) CreateFlat(
-	conn *sql.Conn,
-	ctx context.Context,
-	flatId int,
-	homeId int,
-	price int,
-	rooms int) (*entities.Flat, error) {
-	defer conn.Close()
+const flatColumns = "id, number, price, rooms, home_id, status, moderator_id, claimed_at, decline_code, decline_text, resubmissions, created_by, market_status, market_changed_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	txn, err := conn.BeginTx(ctx, nil)
+func (f FlatStorage) scanFlat(row rowScanner) (*entities.Flat, error) {
+	var flat entities.Flat
+	var claimedBy, declineCode, declineText, createdBy *string
+	err := row.Scan(
+		&flat.Id,
+		&flat.Number,
+		&flat.Price,
+		&flat.Rooms,
+		&flat.HomeId,
+		&flat.Status,
+		&claimedBy,
+		&flat.ClaimedAt,
+		&declineCode,
+		&declineText,
+		&flat.Resubmissions,
+		&createdBy,
+		&flat.MarketStatus,
+		&flat.MarketChanged,
+	)
 	if err != nil {
 		return nil, err
 	}
+	if claimedBy != nil {
+		flat.ModeratorId = *claimedBy
+	}
+	if declineCode != nil {
+		flat.DeclineReason = &entities.DeclineReason{Code: *declineCode}
+		if declineText != nil {
+			flat.DeclineReason.Text = *declineText
+		}
+	}
+	if createdBy != nil {
+		flat.CreatedBy = *createdBy
+	}
+	return &flat, nil
+}
 
-	queryCheck := "SELECT COUNT(*) FROM flats WHERE home_id=$1 AND number=$2"
-	var amount int
-	err = txn.QueryRow(queryCheck, homeId, flatId).Scan(&amount)
+// checkOwner allows changing the flat only to its creator and to moderators.
+// Flats created from dummy sessions have no creator.
+func (f FlatStorage) checkOwner(flat *entities.Flat, userId string, admin bool) error {
+	if admin || flat.CreatedBy != "" && flat.CreatedBy == userId {
+		return nil
+	}
+	return ErrNotFlatOwner
+}
+
+// lockFlat reads the flat and locks its row until the end of txn.
+func (f FlatStorage) lockFlat(txn pgx.Tx, ctx context.Context, flatId int, homeId int) (*entities.Flat, error) {
+	query := "SELECT " + flatColumns + " FROM flats WHERE number=$1 AND home_id=$2 FOR UPDATE"
+	flat, err := f.scanFlat(txn.QueryRow(ctx, query, flatId, homeId))
 	if err != nil {
+		return nil, notFound(err, ErrFlatNotFound)
+	}
+	return flat, nil
+}
+
+// CreateFlat inserts the flat, bumps updated_at of the house and writes
+// the FLAT_CREATED event in a single round trip.
+func (f FlatStorage) CreateFlat(
+	txn pgx.Tx,
+	ctx context.Context,
+	flatId int,
+	homeId int,
+	price int,
+	rooms int,
+	createdBy string) (*entities.Flat, error) {
+	flat := entities.Flat{
+		Number:       flatId,
+		Price:        price,
+		HomeId:       homeId,
+		Rooms:        rooms,
+		Status:       "created",
+		CreatedBy:    createdBy,
+		MarketStatus: string(entities.ACTIVE),
+	}
+	batch := &pgx.Batch{}
+	queryFlat := "INSERT INTO flats (number, price, rooms, home_id, status, created_by) VALUES ($1, $2, $3, $4, 'created', $5) RETURNING id"
+	batch.Queue(queryFlat, flatId, price, rooms, homeId, nullString(createdBy)).QueryRow(func(row pgx.Row) error {
+		return row.Scan(&flat.Id)
+	})
+	queueTouchHome(batch, homeId, time.Now().UTC(), true)
+	if err := f.outbox.AddFlatCreatedEvent(batch, flat); err != nil {
 		return nil, err
 	}
-	if amount != 0 {
-		return nil, errors.New("flat already exists")
+	err := sendBatch(ctx, txn, batch)
+	if isUniqueViolation(err) {
+		return nil, ErrFlatExists
+	}
+	if isForeignKeyViolation(err) {
+		return nil, ErrHouseNotFound
 	}
-
-	queryFlat := "INSERT INTO flats (number, price, rooms, home_id, status) VALUES ($1, $2, $3, $4, 'created')"
-	queryHome := "UPDATE homes SET updated_at=$1 WHERE id=$2"
-	_, err = txn.Exec(queryFlat, flatId, price, rooms, homeId)
 	if err != nil {
 		return nil, err
 	}
-	_, err = txn.Exec(queryHome, time.Now().UTC(), homeId)
+
+	return &flat, nil
+}
+
+// ImportFlats loads flats into the house with COPY. All of them are created
+// by createdBy with the created status. The house's updated_at is bumped and
+// a FLAT_CREATED event is written for every flat, as CreateFlat does.
+func (f FlatStorage) ImportFlats(
+	txn pgx.Tx,
+	ctx context.Context,
+	homeId int,
+	flats []entities.Flat,
+	createdBy string) ([]entities.Flat, error) {
+	numbers := make([]int, len(flats))
+	for i := range flats {
+		numbers[i] = flats[i].Number
+	}
+	columns := []string{"number", "price", "rooms", "home_id", "status", "created_by"}
+	_, err := txn.CopyFrom(ctx, pgx.Identifier{"flats"}, columns, pgx.CopyFromSlice(len(flats), func(i int) ([]any, error) {
+		return []any{flats[i].Number, flats[i].Price, flats[i].Rooms, homeId, string(entities.CREATED), nullString(createdBy)}, nil
+	}))
+	if isUniqueViolation(err) {
+		return nil, ErrFlatExists
+	}
+	if isForeignKeyViolation(err) {
+		return nil, ErrHouseNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	err = txn.Commit()
-	if err != nil {
+	now := time.Now().UTC()
+	batch := &pgx.Batch{}
+	queueTouchHome(batch, homeId, now, true)
+	query := "SELECT " + flatColumns + " FROM flats WHERE home_id=$1 AND number = ANY($2::INT[]) ORDER BY number"
+	result := make([]entities.Flat, 0, len(flats))
+	batch.Queue(query, homeId, numbers).Query(func(rows pgx.Rows) error {
+		for rows.Next() {
+			flat, errscan := f.scanFlat(rows)
+			if errscan != nil {
+				return errscan
+			}
+			result = append(result, *flat)
+		}
+		return rows.Err()
+	})
+	queryOutbox := `INSERT INTO outbox (event_type, payload, created_at)
+		SELECT $1, jsonb_build_object('flat', jsonb_build_object(
+			'global_id', id, 'id', number, 'price', price, 'rooms', rooms,
+			'house_id', home_id, 'status', status, 'market_status', market_status)), $2
+		FROM flats WHERE home_id=$3 AND number = ANY($4::INT[]) ORDER BY id`
+	batch.Queue(queryOutbox, entities.FLAT_CREATED, now, homeId, numbers)
+	if err = sendBatch(ctx, txn, batch); err != nil {
 		return nil, err
 	}
-	return &entities.Flat{
-		Number: flatId,
-		Price:  price,
-		HomeId: homeId,
-		Rooms:  rooms,
-		Status: "created",
-	}, nil
+
+	return result, nil
+}
+
+// queueTouchHome queues bumping updated_at of the house, which makes cached
+// pages of the house unreachable. If live is set, a deleted or missing house
+// fails the batch with ErrHouseNotFound.
+func queueTouchHome(batch *pgx.Batch, homeId int, now time.Time, live bool) {
+	query := "UPDATE homes SET updated_at=$1 WHERE id=$2"
+	if !live {
+		batch.Queue(query, now, homeId)
+		return
+	}
+	batch.Queue(query+" AND deleted_at IS NULL", now, homeId).Exec(func(tag pgconn.CommandTag) error {
+		if tag.RowsAffected() == 0 {
+			return ErrHouseNotFound
+		}
+		return nil
+	})
+}
+
+// nullString maps the empty string to NULL.
+func nullString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
 }
 
 func (f FlatStorage) getStatus(s entities.ModerationStatus) string {
@@ -68,86 +208,516 @@ func (f FlatStorage) getStatus(s entities.ModerationStatus) string {
 	}[s]
 }
 
+// UpdateFlat applies a moderator decision. Approving or declining requires
+// an active claim of moderatorId, declining requires declineReason. Price
+// and rooms of an approved or declined flat can be corrected without a
+// claim as long as the status is kept.
 func (f FlatStorage) UpdateFlat(
-	conn *sql.Conn,
+	txn pgx.Tx,
 	ctx context.Context,
 	flatId int,
 	homeId int,
 	price int,
 	rooms int,
-	status entities.ModerationStatus) (*entities.Flat, error) {
-	defer conn.Close()
+	status entities.ModerationStatus,
+	declineReason *entities.DeclineReason,
+	moderatorId string,
+	claimTimeout time.Duration) (*entities.Flat, error) {
+	previous, err := f.lockFlat(txn, ctx, flatId, homeId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if err := entities.ValidateTransition(entities.ModerationStatus(previous.Status), status); err != nil {
+		return nil, illegalTransition(err)
+	}
+	claimActive := previous.Status == string(entities.ON_MODERATION) &&
+		previous.ModeratorId != "" &&
+		previous.ClaimedAt != nil &&
+		previous.ClaimedAt.After(now.Add(-claimTimeout))
+	if claimActive && previous.ModeratorId != moderatorId {
+		return nil, ErrFlatClaimed
+	}
+	decision := string(status) != previous.Status
+	if (status == entities.APPROVED || status == entities.DECLINED) && decision && !claimActive {
+		return nil, ErrFlatNotClaimed
+	}
+
+	flat := *previous
+	flat.Price = price
+	flat.Rooms = rooms
+	flat.Status = f.getStatus(status)
+	flat.ModeratorId = ""
+	flat.ClaimedAt = nil
+	if status == entities.ON_MODERATION {
+		flat.ModeratorId = moderatorId
+		flat.ClaimedAt = &now
+	}
+	if status == entities.DECLINED {
+		flat.DeclineReason = declineReason
+	} else if status != entities.ModerationStatus(previous.Status) {
+		flat.DeclineReason = nil
+	}
+
+	var claimedBy, declineCode, declineText *string
+	if flat.ClaimedAt != nil {
+		claimedBy = &flat.ModeratorId
+	}
+	reason := ""
+	if flat.DeclineReason != nil {
+		declineCode = &flat.DeclineReason.Code
+		declineText = &flat.DeclineReason.Text
+		if status == entities.DECLINED {
+			reason = flat.DeclineReason.Code + ": " + flat.DeclineReason.Text
+		}
+	}
+
+	queryFlat := `UPDATE flats SET price=$1, rooms=$2, status=$3, moderator_id=$4, claimed_at=$5, decline_code=$6, decline_text=$7
+		WHERE number=$8 AND home_id=$9`
+	batch := &pgx.Batch{}
+	batch.Queue(queryFlat, price, rooms, status, claimedBy, flat.ClaimedAt, declineCode, declineText, flatId, homeId)
+	queueTouchHome(batch, homeId, now, false)
+	f.history.AddEvent(batch, entities.FlatModerationEvent{
+		HomeId:         homeId,
+		FlatNumber:     flatId,
+		ActorId:        moderatorId,
+		PreviousStatus: previous.Status,
+		NewStatus:      flat.Status,
+		PreviousPrice:  previous.Price,
+		NewPrice:       price,
+		PreviousRooms:  previous.Rooms,
+		NewRooms:       rooms,
+		Reason:         reason,
+		CreatedAt:      now,
+	})
+	if previous.Status != flat.Status {
+		err = f.outbox.AddEvent(batch, entities.FLAT_STATUS_CHANGED, entities.FlatEvent{
+			Flat:           flat,
+			PreviousStatus: previous.Status,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = sendBatch(ctx, txn, batch); err != nil {
+		return nil, err
+	}
+
+	return &flat, nil
+}
 
-	txn, err := conn.BeginTx(ctx, nil)
+// ClaimFlat moves the flat to on_moderation and assigns it to moderatorId.
+// The row is locked for the duration of the transaction, so concurrent
+// claims are serialized and only the first one succeeds. A flat may be
+// claimed if it is created, not assigned to anybody, already claimed by
+// the same moderator or its claim is older than claimTimeout; otherwise
+// ErrFlatClaimed is returned.
+func (f FlatStorage) ClaimFlat(
+	txn pgx.Tx,
+	ctx context.Context,
+	flatId int,
+	homeId int,
+	moderatorId string,
+	claimTimeout time.Duration) (*entities.Flat, error) {
+	previous, err := f.lockFlat(txn, ctx, flatId, homeId)
 	if err != nil {
 		return nil, err
 	}
 
-	queryFlat := "UPDATE flats SET price=$1, rooms=$2, status=$3 WHERE number=$4 AND home_id=$5"
-	queryHome := "UPDATE homes SET updated_at=$1 WHERE id=$2"
-	_, err = txn.Exec(queryFlat, price, rooms, status, flatId, homeId)
+	now := time.Now().UTC()
+	if _, err := entities.CLAIM.Apply(entities.ModerationStatus(previous.Status)); err != nil {
+		return nil, illegalTransition(err)
+	}
+	claimable := previous.Status == string(entities.CREATED) ||
+		previous.ModeratorId == "" ||
+		previous.ModeratorId == moderatorId ||
+		previous.ClaimedAt == nil ||
+		!previous.ClaimedAt.After(now.Add(-claimTimeout))
+	if !claimable {
+		return nil, ErrFlatClaimed
+	}
+
+	query := "UPDATE flats SET status='on_moderation', moderator_id=$1, claimed_at=$2 WHERE number=$3 AND home_id=$4"
+	batch := &pgx.Batch{}
+	batch.Queue(query, moderatorId, now, flatId, homeId)
+	flat := *previous
+	flat.Status = string(entities.ON_MODERATION)
+	flat.ModeratorId = moderatorId
+	flat.ClaimedAt = &now
+	f.history.AddEvent(batch, entities.FlatModerationEvent{
+		HomeId:         homeId,
+		FlatNumber:     flatId,
+		ActorId:        moderatorId,
+		PreviousStatus: previous.Status,
+		NewStatus:      flat.Status,
+		PreviousPrice:  flat.Price,
+		NewPrice:       flat.Price,
+		PreviousRooms:  flat.Rooms,
+		NewRooms:       flat.Rooms,
+		Reason:         "claimed for review",
+		CreatedAt:      now,
+	})
+	if previous.Status != flat.Status {
+		err = f.outbox.AddEvent(batch, entities.FLAT_STATUS_CHANGED, entities.FlatEvent{
+			Flat:           flat,
+			PreviousStatus: previous.Status,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = sendBatch(ctx, txn, batch); err != nil {
+		return nil, err
+	}
+
+	return &flat, nil
+}
+
+// ResubmitFlat lets the creator of a declined flat (or a moderator on their
+// behalf) fix it and return it to the moderation queue, at most
+// maxResubmissions times.
+func (f FlatStorage) ResubmitFlat(
+	txn pgx.Tx,
+	ctx context.Context,
+	flatId int,
+	homeId int,
+	price int,
+	rooms int,
+	userId string,
+	admin bool,
+	maxResubmissions int) (*entities.Flat, error) {
+	previous, err := f.lockFlat(txn, ctx, flatId, homeId)
 	if err != nil {
 		return nil, err
 	}
-	_, err = txn.Exec(queryHome, time.Now().UTC(), homeId)
+	if err := f.checkOwner(previous, userId, admin); err != nil {
+		return nil, err
+	}
+	status, err := entities.RESUBMIT.Apply(entities.ModerationStatus(previous.Status))
+	if err != nil {
+		return nil, illegalTransition(err)
+	}
+	if previous.Resubmissions >= maxResubmissions {
+		return nil, ErrResubmissionsReached
+	}
+
+	now := time.Now().UTC()
+	query := `UPDATE flats SET price=$1, rooms=$2, status=$3, moderator_id=NULL, claimed_at=NULL,
+		decline_code=NULL, decline_text=NULL, resubmissions=resubmissions+1
+		WHERE number=$4 AND home_id=$5`
+	batch := &pgx.Batch{}
+	batch.Queue(query, price, rooms, status, flatId, homeId)
+	queueTouchHome(batch, homeId, now, false)
+	flat := *previous
+	flat.Price = price
+	flat.Rooms = rooms
+	flat.Status = f.getStatus(status)
+	flat.ModeratorId = ""
+	flat.ClaimedAt = nil
+	flat.DeclineReason = nil
+	flat.Resubmissions++
+	f.history.AddEvent(batch, entities.FlatModerationEvent{
+		HomeId:         homeId,
+		FlatNumber:     flatId,
+		ActorId:        userId,
+		PreviousStatus: previous.Status,
+		NewStatus:      flat.Status,
+		PreviousPrice:  previous.Price,
+		NewPrice:       price,
+		PreviousRooms:  previous.Rooms,
+		NewRooms:       rooms,
+		Reason:         "resubmitted",
+		CreatedAt:      now,
+	})
+	err = f.outbox.AddEvent(batch, entities.FLAT_STATUS_CHANGED, entities.FlatEvent{
+		Flat:           flat,
+		PreviousStatus: previous.Status,
+	})
 	if err != nil {
 		return nil, err
 	}
+	if err = sendBatch(ctx, txn, batch); err != nil {
+		return nil, err
+	}
+
+	return &flat, nil
+}
 
-	err = txn.Commit()
+// GetFlat returns the flat or ErrFlatNotFound.
+func (f FlatStorage) GetFlat(
+	q queryer,
+	ctx context.Context,
+	flatId int,
+	homeId int) (*entities.Flat, error) {
+	query := "SELECT " + flatColumns + " FROM flats WHERE number=$1 AND home_id=$2"
+	flat, err := f.scanFlat(q.QueryRow(ctx, query, flatId, homeId))
+	if err != nil {
+		return nil, notFound(err, ErrFlatNotFound)
+	}
+	return flat, nil
+}
+
+// GetUserFlats returns flats created by userId in all houses and statuses.
+func (f FlatStorage) GetUserFlats(
+	q queryer,
+	ctx context.Context,
+	userId string) ([]entities.Flat, error) {
+	query := "SELECT " + flatColumns + " FROM flats WHERE created_by=$1 AND home_id IN (SELECT id FROM homes WHERE deleted_at IS NULL) ORDER BY home_id, number"
+	rows, err := q.Query(ctx, query, userId)
 	if err != nil {
 		return nil, err
 	}
-	return &entities.Flat{
-		Number: flatId,
-		HomeId: homeId,
-		Price:  price,
-		Rooms:  rooms,
-		Status: f.getStatus(status),
-	}, nil
+	defer rows.Close()
+	result := make([]entities.Flat, 0)
+	for rows.Next() {
+		flat, errscan := f.scanFlat(rows)
+		if errscan != nil {
+			return nil, errscan
+		}
+		result = append(result, *flat)
+	}
+	return result, rows.Err()
 }
 
 func (f FlatStorage) FilterFlats(
-	conn *sql.Conn,
+	q queryer,
+	ctx context.Context,
+	homeId int,
+	admin bool,
+	filter entities.FlatFilter) (*entities.FlatPage, error) {
+	filter.HouseIds = []int{homeId}
+	filter.Statuses = nil
+	filter.MarketStatuses = []string{string(entities.ACTIVE)}
+	filter.IncludeDeleted = admin
+	if !admin {
+		filter.Statuses = []string{string(entities.APPROVED)}
+	}
+	return f.queryPage(q, ctx, filter)
+}
+
+// SetMarketStatus takes an active flat off the market. Only its creator or
+// a moderator may do it. The house's updated_at is bumped, so cached pages
+// without the flat are built on the next read.
+func (f FlatStorage) SetMarketStatus(
+	txn pgx.Tx,
 	ctx context.Context,
+	flatId int,
 	homeId int,
-	admin bool) ([]entities.Flat, error) {
-	defer conn.Close()
+	status entities.MarketStatus,
+	userId string,
+	admin bool) (*entities.Flat, error) {
+	flat, err := f.lockFlat(txn, ctx, flatId, homeId)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.checkOwner(flat, userId, admin); err != nil {
+		return nil, err
+	}
+	if flat.MarketStatus != string(entities.ACTIVE) {
+		return nil, ErrFlatOffMarket
+	}
 
-	txn, err := conn.BeginTx(ctx, nil)
+	now := time.Now().UTC()
+	query := "UPDATE flats SET market_status=$1, market_changed_at=$2 WHERE id=$3"
+	batch := &pgx.Batch{}
+	batch.Queue(query, status, now, flat.Id)
+	queueTouchHome(batch, homeId, now, false)
+	if err = sendBatch(ctx, txn, batch); err != nil {
+		return nil, err
+	}
+
+	flat.MarketStatus = string(status)
+	flat.MarketChanged = &now
+	return flat, nil
+}
+
+// StartModeration moves every created flat of the house to on_moderation
+// without claiming them, so any moderator can still take them with ClaimFlat.
+// The house row is locked to serialize concurrent starts, and its updated_at
+// is bumped if anything changed to invalidate cached pages.
+func (f FlatStorage) StartModeration(
+	txn pgx.Tx,
+	ctx context.Context,
+	homeId int,
+	moderatorId string) ([]entities.Flat, error) {
+	var lockedId int
+	err := txn.QueryRow(ctx, "SELECT id FROM homes WHERE id=$1 AND deleted_at IS NULL FOR UPDATE", homeId).Scan(&lockedId)
 	if err != nil {
+		return nil, notFound(err, ErrHouseNotFound)
+	}
+
+	query := "UPDATE flats SET status='on_moderation' WHERE home_id=$1 AND status='created' RETURNING " + flatColumns
+	rows, err := txn.Query(ctx, query, homeId)
+	if err != nil {
+		return nil, err
+	}
+	flats := make([]entities.Flat, 0)
+	for rows.Next() {
+		flat, errscan := f.scanFlat(rows)
+		if errscan != nil {
+			rows.Close()
+			return nil, errscan
+		}
+		flats = append(flats, *flat)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	if len(flats) == 0 {
+		return flats, nil
+	}
 
-	if admin {
-		query := "UPDATE flats SET status='on_moderation' WHERE home_id=$1 AND status='created'"
-		_, err = txn.Exec(query, homeId)
+	now := time.Now().UTC()
+	batch := &pgx.Batch{}
+	for _, flat := range flats {
+		f.history.AddEvent(batch, entities.FlatModerationEvent{
+			HomeId:         homeId,
+			FlatNumber:     flat.Number,
+			ActorId:        moderatorId,
+			PreviousStatus: string(entities.CREATED),
+			NewStatus:      flat.Status,
+			PreviousPrice:  flat.Price,
+			NewPrice:       flat.Price,
+			PreviousRooms:  flat.Rooms,
+			NewRooms:       flat.Rooms,
+			Reason:         "moderation of the house started",
+			CreatedAt:      now,
+		})
+		err = f.outbox.AddEvent(batch, entities.FLAT_STATUS_CHANGED, entities.FlatEvent{
+			Flat:           flat,
+			PreviousStatus: string(entities.CREATED),
+		})
 		if err != nil {
 			return nil, err
 		}
 	}
+	queueTouchHome(batch, homeId, now, false)
+	if err = sendBatch(ctx, txn, batch); err != nil {
+		return nil, err
+	}
 
-	query := "SELECT number, price, rooms, home_id, status FROM flats WHERE home_id=$1"
-	if !admin {
-		query += " AND status='approved'"
+	return flats, nil
+}
+
+type flatSort struct {
+	column string
+	value  func(flat *entities.Flat) int64
+}
+
+// flatSortColumns lists orderings of flat listings. Recency is the order of
+// creation given by the surrogate id.
+var flatSortColumns = map[string]flatSort{
+	"price":   {"price", func(flat *entities.Flat) int64 { return int64(flat.Price) }},
+	"rooms":   {"rooms", func(flat *entities.Flat) int64 { return int64(flat.Rooms) }},
+	"number":  {"number", func(flat *entities.Flat) int64 { return int64(flat.Number) }},
+	"created": {"id", func(flat *entities.Flat) int64 { return flat.Id }},
+}
+
+func (f FlatStorage) SearchFlats(
+	q queryer,
+	ctx context.Context,
+	filter entities.FlatFilter) (*entities.FlatPage, error) {
+	return f.queryPage(q, ctx, filter)
+}
+
+// queryPage selects one page of flats matching the filter, ordered by the
+// sort column and id.
+func (f FlatStorage) queryPage(
+	q queryer,
+	ctx context.Context,
+	filter entities.FlatFilter) (*entities.FlatPage, error) {
+	sort, ok := flatSortColumns[filter.Sort]
+	if !ok {
+		filter.Sort = "created"
+		sort = flatSortColumns["created"]
+	}
+	column := sort.column
+	order := "ASC"
+	cmp := ">"
+	if filter.Desc {
+		order = "DESC"
+		cmp = "<"
+	}
+	sortKey := filter.Sort + ":" + order
+
+	conditions := make([]string, 0)
+	var args queryArgs
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, "status = ANY("+args.add(filter.Statuses)+"::FLAT_STATUS[])")
+	}
+	if len(filter.MarketStatuses) > 0 {
+		conditions = append(conditions, "market_status = ANY("+args.add(filter.MarketStatuses)+"::FLAT_MARKET_STATUS[])")
+	}
+	if filter.PriceMin > 0 {
+		conditions = append(conditions, "price >= "+args.add(filter.PriceMin))
 	}
-	rows, err := txn.Query(query, homeId)
+	if filter.PriceMax > 0 {
+		conditions = append(conditions, "price <= "+args.add(filter.PriceMax))
+	}
+	if len(filter.Rooms) > 0 {
+		conditions = append(conditions, "rooms = ANY("+args.add(filter.Rooms)+"::INT[])")
+	}
+	if len(filter.HouseIds) > 0 {
+		conditions = append(conditions, "home_id = ANY("+args.add(filter.HouseIds)+"::INT[])")
+	}
+	homeConditions := make([]string, 0)
+	if filter.Developer != "" {
+		homeConditions = append(homeConditions, "developer = "+args.add(filter.Developer))
+	}
+	if filter.YearFrom > 0 {
+		homeConditions = append(homeConditions, "year >= "+args.add(filter.YearFrom))
+	}
+	if filter.YearTo > 0 {
+		homeConditions = append(homeConditions, "year <= "+args.add(filter.YearTo))
+	}
+	if !filter.IncludeDeleted {
+		homeConditions = append(homeConditions, "deleted_at IS NULL")
+	}
+	if len(homeConditions) > 0 {
+		conditions = append(conditions, "home_id IN (SELECT id FROM homes WHERE "+strings.Join(homeConditions, " AND ")+")")
+	}
+	if filter.Cursor != "" {
+		c, err := DecodeCursor(filter.Cursor, sortKey)
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor.Wrap(err)
+		}
+		conditions = append(conditions, "("+column+", id) "+cmp+" ("+args.add(value)+", "+args.add(c.Id)+")")
+	}
+
+	query := "SELECT " + flatColumns + " FROM flats"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY " + column + " " + order + ", id " + order + " LIMIT " + args.add(filter.Limit+1)
+
+	rows, err := q.Query(ctx, query, args.values...)
 	if err != nil {
 		return nil, err
 	}
-	result := make([]entities.Flat, 0)
+	defer rows.Close()
+	flats := make([]entities.Flat, 0, filter.Limit)
 	for rows.Next() {
-		var flat entities.Flat
-		errscan := rows.Scan(&flat.Number, &flat.Price, &flat.Rooms, &flat.HomeId, &flat.Status)
+		flat, errscan := f.scanFlat(rows)
 		if errscan != nil {
 			return nil, errscan
 		}
-		result = append(result, flat)
+		flats = append(flats, *flat)
 	}
-
-	err = txn.Commit()
-	if err != nil {
+	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-	return result, nil
+
+	page := entities.FlatPage{Flats: flats}
+	if len(flats) > filter.Limit {
+		page.Flats = flats[:filter.Limit]
+		last := &page.Flats[filter.Limit-1]
+		page.NextCursor = Cursor{Sort: sortKey, Value: strconv.FormatInt(sort.value(last), 10), Id: last.Id}.Encode()
+	}
+	return &page, nil
 }
@@ -3,7 +3,10 @@ package storages
 import (
 	"bootcamp_task/storage/entities"
 	"context"
-	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,31 +14,20 @@ type HomeStorage struct {
 }
 
 func (h HomeStorage) CreateHome(
-	conn *sql.Conn,
+	txn pgx.Tx,
 	ctx context.Context,
 	address string,
 	year int,
 	developer string,
 	reviewer string) (*entities.Home, error) {
-	defer conn.Close()
-
-	txn, err := conn.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, err
-	}
-
 	creationTime := time.Now().UTC()
 	var insertedId int
 	query := "INSERT INTO homes (address, year, created_at, updated_at, developer, reviewer) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
-	err = txn.QueryRow(query, address, year, creationTime, creationTime, developer, reviewer).Scan(&insertedId)
+	err := txn.QueryRow(ctx, query, address, year, creationTime, creationTime, developer, reviewer).Scan(&insertedId)
 	if err != nil {
 		return nil, err
 	}
 
-	err = txn.Commit()
-	if err != nil {
-		return nil, err
-	}
 	return &entities.Home{
 		Id:        insertedId,
 		Address:   address,
@@ -48,51 +40,202 @@ func (h HomeStorage) CreateHome(
 }
 
 func (h HomeStorage) GetLastHomeUpdate(
-	conn *sql.Conn,
+	q queryer,
 	ctx context.Context,
 	homeId int) (time.Time, error) {
-	defer conn.Close()
+	query := "SELECT updated_at FROM homes WHERE id=$1 AND deleted_at IS NULL"
+	var lastUpdated time.Time
+	err := q.QueryRow(ctx, query, homeId).Scan(&lastUpdated)
+	if err != nil {
+		return time.Unix(0, 0), notFound(err, ErrHouseNotFound)
+	}
+
+	return lastUpdated, nil
+}
 
-	txn, err := conn.BeginTx(ctx, nil)
+func (h HomeStorage) GetHomeReviewer(
+	q queryer,
+	ctx context.Context,
+	homeId int) (string, error) {
+	query := "SELECT reviewer FROM homes WHERE id=$1 AND deleted_at IS NULL"
+	var reviewer string
+	err := q.QueryRow(ctx, query, homeId).Scan(&reviewer)
 	if err != nil {
-		return time.Unix(0, 0), err
+		return "", notFound(err, ErrHouseNotFound)
 	}
 
-	query := "SELECT updated_at FROM homes WHERE id=$1"
-	var lastUpdated time.Time
-	err = txn.QueryRow(query, homeId).Scan(&lastUpdated)
+	return reviewer, nil
+}
+
+const homeColumns = "id, address, year, developer, reviewer, created_at, updated_at, deleted_at"
+
+func (h HomeStorage) scanHome(row rowScanner) (*entities.Home, error) {
+	var home entities.Home
+	err := row.Scan(&home.Id, &home.Address, &home.Year, &home.Developer, &home.Reviewer, &home.CreatedAt, &home.UpdatedAt, &home.DeletedAt)
 	if err != nil {
-		return time.Unix(0, 0), err
+		return nil, err
+	}
+	return &home, nil
+}
+
+// homeSortColumns lists columns GET /houses can be sorted by. Every sort is
+// completed by id, so (column, id) is unique and usable as a keyset cursor.
+var homeSortColumns = map[string]func(h *entities.Home) string{
+	"id":         func(h *entities.Home) string { return strconv.Itoa(h.Id) },
+	"address":    func(h *entities.Home) string { return h.Address },
+	"year":       func(h *entities.Home) string { return strconv.Itoa(h.Year) },
+	"created_at": func(h *entities.Home) string { return h.CreatedAt.Format(time.RFC3339Nano) },
+	"updated_at": func(h *entities.Home) string { return h.UpdatedAt.Format(time.RFC3339Nano) },
+}
+
+func (h HomeStorage) SearchHomes(
+	q queryer,
+	ctx context.Context,
+	filter entities.HomeFilter) (*entities.HomePage, error) {
+	sortValue, ok := homeSortColumns[filter.Sort]
+	if !ok {
+		filter.Sort = "id"
+		sortValue = homeSortColumns["id"]
+	}
+	order := "ASC"
+	cmp := ">"
+	if filter.Desc {
+		order = "DESC"
+		cmp = "<"
 	}
+	sortKey := filter.Sort + ":" + order
 
-	err = txn.Commit()
+	conditions := make([]string, 0)
+	var args queryArgs
+	if filter.Address != "" {
+		conditions = append(conditions, "address ILIKE '%' || "+args.add(escapeLike(filter.Address))+" || '%'")
+	}
+	if filter.Developer != "" {
+		conditions = append(conditions, "developer = "+args.add(filter.Developer))
+	}
+	if filter.YearFrom > 0 {
+		conditions = append(conditions, "year >= "+args.add(filter.YearFrom))
+	}
+	if filter.YearTo > 0 {
+		conditions = append(conditions, "year <= "+args.add(filter.YearTo))
+	}
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if filter.HasApprovedFlats {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM flats WHERE flats.home_id = homes.id AND flats.status = 'approved' AND flats.market_status = 'active')")
+	}
+	if filter.Cursor != "" {
+		c, err := DecodeCursor(filter.Cursor, sortKey)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "("+filter.Sort+", id) "+cmp+" ("+args.add(c.Value)+", "+args.add(c.Id)+")")
+	}
+
+	query := "SELECT " + homeColumns + " FROM homes"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY " + filter.Sort + " " + order + ", id " + order + " LIMIT " + args.add(filter.Limit+1)
+
+	rows, err := q.Query(ctx, query, args.values...)
 	if err != nil {
-		return time.Unix(0, 0), err
+		return nil, err
 	}
-	return lastUpdated, nil
+	defer rows.Close()
+	homes := make([]entities.Home, 0, filter.Limit)
+	for rows.Next() {
+		home, errscan := h.scanHome(rows)
+		if errscan != nil {
+			return nil, errscan
+		}
+		homes = append(homes, *home)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := entities.HomePage{Homes: homes}
+	if len(homes) > filter.Limit {
+		page.Homes = homes[:filter.Limit]
+		last := &page.Homes[filter.Limit-1]
+		page.NextCursor = Cursor{Sort: sortKey, Value: sortValue(last), Id: int64(last.Id)}.Encode()
+	}
+	return &page, nil
 }
 
-func (h HomeStorage) GetHomeReviewer(
-	conn *sql.Conn,
+// UpdateHome changes the given fields of the house if it was not updated
+// since expectedUpdatedAt. Nil fields are kept.
+func (h HomeStorage) UpdateHome(
+	txn pgx.Tx,
 	ctx context.Context,
-	homeId int) (string, error) {
-	defer conn.Close()
+	homeId int,
+	address *string,
+	year *int,
+	developer *string,
+	expectedUpdatedAt time.Time) (*entities.Home, error) {
+	query := `UPDATE homes SET address=COALESCE($1, address), year=COALESCE($2, year), developer=COALESCE($3, developer), updated_at=$4
+		WHERE id=$5 AND updated_at=$6 AND deleted_at IS NULL RETURNING ` + homeColumns
+	home, err := h.scanHome(txn.QueryRow(ctx, query, address, year, developer, time.Now().UTC(), homeId, expectedUpdatedAt.UTC()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		deleted, errstate := h.isDeleted(txn, ctx, homeId)
+		if errstate != nil {
+			return nil, errstate
+		}
+		if deleted {
+			return nil, ErrHouseNotFound
+		}
+		return nil, ErrHouseModified
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return home, nil
+}
 
-	txn, err := conn.BeginTx(ctx, nil)
+// DeleteHome hides the house and its flats from clients. Bumping updated_at
+// makes cached pages of the house unreachable.
+func (h HomeStorage) DeleteHome(
+	q queryer,
+	ctx context.Context,
+	homeId int) (*entities.Home, error) {
+	now := time.Now().UTC()
+	query := "UPDATE homes SET deleted_at=$1, updated_at=$1 WHERE id=$2 AND deleted_at IS NULL RETURNING " + homeColumns
+	home, err := h.scanHome(q.QueryRow(ctx, query, now, homeId))
 	if err != nil {
-		return "", err
+		return nil, notFound(err, ErrHouseNotFound)
 	}
+	return home, nil
+}
 
-	query := "SELECT reviewer FROM homes WHERE id=$1"
-	var reviewer string
-	err = txn.QueryRow(query, homeId).Scan(&reviewer)
+func (h HomeStorage) RestoreHome(
+	txn pgx.Tx,
+	ctx context.Context,
+	homeId int) (*entities.Home, error) {
+	query := "UPDATE homes SET deleted_at=NULL, updated_at=$1 WHERE id=$2 AND deleted_at IS NOT NULL RETURNING " + homeColumns
+	home, err := h.scanHome(txn.QueryRow(ctx, query, time.Now().UTC(), homeId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, errstate := h.isDeleted(txn, ctx, homeId); errstate != nil {
+			return nil, errstate
+		}
+		return nil, ErrHouseNotDeleted
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	err = txn.Commit()
+	return home, nil
+}
+
+// isDeleted reads the deletion mark of the house, it is used to tell why a
+// conditional update matched no rows.
+func (h HomeStorage) isDeleted(txn pgx.Tx, ctx context.Context, homeId int) (bool, error) {
+	var deletedAt *time.Time
+	err := txn.QueryRow(ctx, "SELECT deleted_at FROM homes WHERE id=$1", homeId).Scan(&deletedAt)
 	if err != nil {
-		return "", err
+		return false, notFound(err, ErrHouseNotFound)
 	}
-	return reviewer, nil
+	return deletedAt != nil, nil
 }
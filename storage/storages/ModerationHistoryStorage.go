@@ -0,0 +1,91 @@
+package storages
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+	"github.com/jackc/pgx/v5"
+)
+
+type ModerationHistoryStorage struct {
+}
+
+// AddEvent queues recording a moderation step to batch.
+func (m ModerationHistoryStorage) AddEvent(
+	batch *pgx.Batch,
+	event entities.FlatModerationEvent) {
+	query := `INSERT INTO flat_moderation_events (home_id, flat_number, actor_id, previous_status, new_status,
+		previous_price, new_price, previous_rooms, new_rooms, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	var reason *string
+	if event.Reason != "" {
+		reason = &event.Reason
+	}
+	batch.Queue(
+		query,
+		event.HomeId,
+		event.FlatNumber,
+		event.ActorId,
+		event.PreviousStatus,
+		event.NewStatus,
+		event.PreviousPrice,
+		event.NewPrice,
+		event.PreviousRooms,
+		event.NewRooms,
+		reason,
+		event.CreatedAt,
+	)
+}
+
+// GetHistory returns moderation steps of the flat, oldest first, or
+// ErrFlatNotFound if the flat does not exist.
+func (m ModerationHistoryStorage) GetHistory(
+	q queryer,
+	ctx context.Context,
+	homeId int,
+	flatId int) ([]entities.FlatModerationEvent, error) {
+	var exists bool
+	queryExists := "SELECT EXISTS(SELECT 1 FROM flats WHERE home_id=$1 AND number=$2)"
+	err := q.QueryRow(ctx, queryExists, homeId, flatId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrFlatNotFound
+	}
+
+	query := `SELECT id, home_id, flat_number, actor_id, previous_status, new_status,
+		previous_price, new_price, previous_rooms, new_rooms, reason, created_at
+		FROM flat_moderation_events WHERE home_id=$1 AND flat_number=$2 ORDER BY id`
+	rows, err := q.Query(ctx, query, homeId, flatId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]entities.FlatModerationEvent, 0)
+	for rows.Next() {
+		var event entities.FlatModerationEvent
+		var reason *string
+		errscan := rows.Scan(
+			&event.Id,
+			&event.HomeId,
+			&event.FlatNumber,
+			&event.ActorId,
+			&event.PreviousStatus,
+			&event.NewStatus,
+			&event.PreviousPrice,
+			&event.NewPrice,
+			&event.PreviousRooms,
+			&event.NewRooms,
+			&reason,
+			&event.CreatedAt,
+		)
+		if errscan != nil {
+			return nil, errscan
+		}
+		if reason != nil {
+			event.Reason = *reason
+		}
+		result = append(result, event)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,92 @@
+package storages
+
+import (
+	"bootcamp_task/storage/entities"
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type OutboxStorage struct {
+}
+
+// AddEvent queues writing event into the outbox to batch, so the event is
+// published if and only if the transaction sending the batch commits.
+func (o OutboxStorage) AddEvent(
+	batch *pgx.Batch,
+	eventType entities.EventType,
+	payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO outbox (event_type, payload, created_at) VALUES ($1, $2, $3)"
+	batch.Queue(query, eventType, body, time.Now().UTC())
+	return nil
+}
+
+// AddFlatCreatedEvent queues the event of the flat inserted earlier in the
+// same batch. The global id of the flat is not known when the batch is
+// built, so it is taken from the sequence of the flats table.
+func (o OutboxStorage) AddFlatCreatedEvent(
+	batch *pgx.Batch,
+	flat entities.Flat) error {
+	body, err := json.Marshal(entities.FlatEvent{Flat: flat})
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO outbox (event_type, payload, created_at)
+		VALUES ($1, jsonb_set($2::jsonb, '{flat,global_id}', to_jsonb(currval(pg_get_serial_sequence('flats', 'id')))), $3)`
+	batch.Queue(query, entities.FLAT_CREATED, body, time.Now().UTC())
+	return nil
+}
+
+// Dispatch locks up to batchSize undelivered events, passes each of them to
+// handle and marks delivered the ones handled without error. Failed events
+// stay in the outbox and are retried on the next call until maxAttempts is
+// reached. Locked rows are skipped, so several dispatchers may run at once.
+// A batch whose transaction is retried is handled again, which the
+// at-least-once delivery allows.
+func (o OutboxStorage) Dispatch(
+	txn pgx.Tx,
+	ctx context.Context,
+	batchSize int,
+	maxAttempts int,
+	handle func(context.Context, entities.OutboxEvent) error) (int, error) {
+	query := "SELECT id, event_type, payload, created_at, attempts FROM outbox WHERE delivered_at IS NULL AND attempts < $1 ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED"
+	rows, err := txn.Query(ctx, query, maxAttempts, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	events := make([]entities.OutboxEvent, 0, batchSize)
+	for rows.Next() {
+		var event entities.OutboxEvent
+		errscan := rows.Scan(&event.Id, &event.Type, &event.Payload, &event.CreatedAt, &event.Attempts)
+		if errscan != nil {
+			rows.Close()
+			return 0, errscan
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	batch := &pgx.Batch{}
+	for _, event := range events {
+		if errhandle := handle(ctx, event); errhandle != nil {
+			batch.Queue("UPDATE outbox SET attempts=attempts+1, last_error=$1 WHERE id=$2", errhandle.Error(), event.Id)
+		} else {
+			batch.Queue("UPDATE outbox SET attempts=attempts+1, delivered_at=$1 WHERE id=$2", time.Now().UTC(), event.Id)
+			delivered++
+		}
+	}
+	if err := sendBatch(ctx, txn, batch); err != nil {
+		return 0, err
+	}
+
+	return delivered, nil
+}
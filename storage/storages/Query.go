@@ -0,0 +1,14 @@
+package storages
+
+import "strconv"
+
+// queryArgs collects arguments of a dynamically built query and returns
+// their placeholders.
+type queryArgs struct {
+	values []interface{}
+}
+
+func (a *queryArgs) add(value interface{}) string {
+	a.values = append(a.values, value)
+	return "$" + strconv.Itoa(len(a.values))
+}
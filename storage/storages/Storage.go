@@ -3,18 +3,41 @@ package storages
 import (
 	"bootcamp_task/config"
 	"bootcamp_task/storage/entities"
+	"bootcamp_task/storage/repositories"
 	"context"
-	"database/sql"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"time"
 )
 
+// enumTypes are registered on every connection, so FLAT_STATUS and
+// FLAT_MARKET_STATUS values and arrays are sent and scanned as strings.
+var enumTypes = []string{"flat_status", "_flat_status", "flat_market_status", "_flat_market_status"}
+
+var (
+	_ repositories.HouseRepository  = (*Storage)(nil)
+	_ repositories.FlatRepository   = (*Storage)(nil)
+	_ repositories.UserRepository   = (*Storage)(nil)
+	_ repositories.OutboxRepository = (*Storage)(nil)
+)
+
 type Storage struct {
 	flats   FlatStorage
 	homes   HomeStorage
 	users   UserStorage
-	db      *sql.DB
-	timeout time.Duration
+	subs    SubscriptionStorage
+	outbox  OutboxStorage
+	history ModerationHistoryStorage
+	db      *pgxpool.Pool
+
+	// timeout limits every storage call, searchTimeout replaces it for
+	// searches and pages of flats. Both are layered on top of the context
+	// of the request.
+	timeout       time.Duration
+	searchTimeout time.Duration
+
+	claimTimeout     time.Duration
+	maxResubmissions int
 }
 
 func NewStorage(cfg *config.Config) *Storage {
@@ -22,8 +45,11 @@ func NewStorage(cfg *config.Config) *Storage {
 	err := s.Init(
 		cfg.BuildPGConnectionString(),
 		cfg.Postgres.MaxConnections,
-		cfg.Postgres.MaxIdleConnections,
-		cfg.Postgres.DataBaseTimeout)
+		cfg.Postgres.StatementCacheCapacity,
+		cfg.Postgres.DataBaseTimeout,
+		cfg.Postgres.SearchTimeout,
+		cfg.Moderation.ClaimTimeout,
+		cfg.Moderation.MaxResubmissions)
 	if err != nil {
 		panic(err)
 	}
@@ -33,132 +59,332 @@ func NewStorage(cfg *config.Config) *Storage {
 func (s *Storage) Init(
 	connectionString string,
 	maxConnections int,
-	maxIdleConnections int,
-	timeout int) error {
-	var err error
-	s.db, err = sql.Open("postgres", connectionString)
+	statementCacheCapacity int,
+	timeout int,
+	searchTimeout int,
+	claimTimeout float64,
+	maxResubmissions int) error {
+	poolConfig, err := pgxpool.ParseConfig(connectionString)
+	if err != nil {
+		return err
+	}
+	poolConfig.MaxConns = int32(maxConnections)
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	if statementCacheCapacity > 0 {
+		poolConfig.ConnConfig.StatementCacheCapacity = statementCacheCapacity
+	}
+	poolConfig.AfterConnect = registerEnumTypes
+	s.db, err = pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		return err
 	}
-	if errping := s.db.Ping(); errping != nil {
+	if errping := s.db.Ping(context.Background()); errping != nil {
 		return errping
 	}
 
-	s.db.SetMaxOpenConns(maxConnections)
-	s.db.SetMaxIdleConns(maxIdleConnections)
 	s.timeout = time.Duration(timeout) * time.Millisecond
-	s.flats = FlatStorage{}
+	s.searchTimeout = time.Duration(searchTimeout) * time.Millisecond
+	if s.searchTimeout <= 0 {
+		s.searchTimeout = s.timeout
+	}
+	s.claimTimeout = time.Duration(claimTimeout * float64(time.Minute))
+	if s.claimTimeout <= 0 {
+		s.claimTimeout = 30 * time.Minute
+	}
+	s.maxResubmissions = maxResubmissions
+	if s.maxResubmissions <= 0 {
+		s.maxResubmissions = 3
+	}
+	s.outbox = OutboxStorage{}
+	s.history = ModerationHistoryStorage{}
+	s.flats = FlatStorage{outbox: s.outbox, history: s.history}
 	s.homes = HomeStorage{}
 	s.users = UserStorage{}
+	s.subs = SubscriptionStorage{}
 	return nil
 }
 
-func (s *Storage) getConnection() (*sql.Conn, error) {
-	conn, errcon := s.db.Conn(context.Background())
-	if errcon != nil {
-		return nil, errcon
+func registerEnumTypes(ctx context.Context, conn *pgx.Conn) error {
+	for _, name := range enumTypes {
+		t, err := conn.LoadType(ctx, name)
+		if err != nil {
+			return err
+		}
+		conn.TypeMap().RegisterType(t)
 	}
-	return conn, nil
-}
-
-func (s *Storage) getContext() (context.Context, func()) {
-	return context.WithTimeout(context.Background(), s.timeout)
+	return nil
 }
 
 func (s *Storage) CreateUser(
+	ctx context.Context,
 	email string,
 	password string,
 	isAdmin bool) (string, error) {
-	conn, err := s.getConnection()
-	if err != nil {
-		return "", err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	return s.users.CreateUser(conn, ctx, email, password, isAdmin)
+	var id string
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		id, err = s.users.CreateUser(txn, ctx, email, password, isAdmin)
+		return err
+	})
+	return id, err
 }
 
-func (s *Storage) GetUser(email string) (*entities.User, error) {
-	conn, err := s.getConnection()
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+func (s *Storage) GetUser(ctx context.Context, email string) (*entities.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	return s.users.GetUser(conn, ctx, email)
+	return s.users.GetUser(s.db, ctx, email)
+}
+
+func (s *Storage) UpdateUserPassword(ctx context.Context, id string, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.users.UpdateUserPassword(s.db, ctx, id, password)
 }
 
 func (s *Storage) CreateHome(
+	ctx context.Context,
 	address string,
 	year int,
 	developer string,
 	reviewer string) (*entities.Home, error) {
-	conn, err := s.getConnection()
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	return s.homes.CreateHome(conn, ctx, address, year, developer, reviewer)
+	var home *entities.Home
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		home, err = s.homes.CreateHome(txn, ctx, address, year, developer, reviewer)
+		return err
+	})
+	return home, err
+}
+
+func (s *Storage) UpdateHome(
+	ctx context.Context,
+	homeId int,
+	address *string,
+	year *int,
+	developer *string,
+	expectedUpdatedAt time.Time) (*entities.Home, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var home *entities.Home
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		home, err = s.homes.UpdateHome(txn, ctx, homeId, address, year, developer, expectedUpdatedAt)
+		return err
+	})
+	return home, err
+}
+
+func (s *Storage) DeleteHome(ctx context.Context, homeId int) (*entities.Home, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.homes.DeleteHome(s.db, ctx, homeId)
+}
+
+func (s *Storage) RestoreHome(ctx context.Context, homeId int) (*entities.Home, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var home *entities.Home
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		home, err = s.homes.RestoreHome(txn, ctx, homeId)
+		return err
+	})
+	return home, err
+}
+
+func (s *Storage) SearchHomes(ctx context.Context, filter entities.HomeFilter) (*entities.HomePage, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.searchTimeout)
+	defer cancel()
+	return s.homes.SearchHomes(s.db, ctx, filter)
 }
 
 func (s *Storage) CreateFlat(
+	ctx context.Context,
 	flatId int,
 	houseId int,
 	price int,
-	rooms int) (*entities.Flat, error) {
-	conn, err := s.getConnection()
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	rooms int,
+	createdBy string) (*entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	return s.flats.CreateFlat(conn, ctx, flatId, houseId, price, rooms)
+	var flat *entities.Flat
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		flat, err = s.flats.CreateFlat(txn, ctx, flatId, houseId, price, rooms, createdBy)
+		return err
+	})
+	return flat, err
+}
+
+// ImportFlats creates flats of the house in bulk with COPY. Numbers of the
+// flats must be unique within the house, otherwise nothing is imported and
+// ErrFlatExists is returned.
+func (s *Storage) ImportFlats(
+	ctx context.Context,
+	houseId int,
+	flats []entities.Flat,
+	createdBy string) ([]entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var imported []entities.Flat
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		imported, err = s.flats.ImportFlats(txn, ctx, houseId, flats, createdBy)
+		return err
+	})
+	return imported, err
 }
 
 func (s *Storage) UpdateFlat(
+	ctx context.Context,
 	flatId int,
 	homeId int,
 	price int,
 	rooms int,
-	status entities.ModerationStatus) (*entities.Flat, error) {
-	conn, err := s.getConnection()
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	status entities.ModerationStatus,
+	declineReason *entities.DeclineReason,
+	moderatorId string) (*entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	return s.flats.UpdateFlat(conn, ctx, flatId, homeId, price, rooms, status)
+	var flat *entities.Flat
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		flat, err = s.flats.UpdateFlat(txn, ctx, flatId, homeId, price, rooms, status, declineReason, moderatorId, s.claimTimeout)
+		return err
+	})
+	return flat, err
+}
+
+func (s *Storage) ResubmitFlat(
+	ctx context.Context,
+	flatId int,
+	homeId int,
+	price int,
+	rooms int,
+	userId string,
+	admin bool) (*entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var flat *entities.Flat
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		flat, err = s.flats.ResubmitFlat(txn, ctx, flatId, homeId, price, rooms, userId, admin, s.maxResubmissions)
+		return err
+	})
+	return flat, err
+}
+
+func (s *Storage) GetUserFlats(ctx context.Context, userId string) ([]entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.flats.GetUserFlats(s.db, ctx, userId)
+}
+
+func (s *Storage) GetFlat(ctx context.Context, flatId int, homeId int) (*entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.flats.GetFlat(s.db, ctx, flatId, homeId)
+}
+
+func (s *Storage) ClaimFlat(
+	ctx context.Context,
+	flatId int,
+	homeId int,
+	moderatorId string) (*entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var flat *entities.Flat
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		flat, err = s.flats.ClaimFlat(txn, ctx, flatId, homeId, moderatorId, s.claimTimeout)
+		return err
+	})
+	return flat, err
 }
 
 func (s *Storage) FilterFlats(
+	ctx context.Context,
 	homeId int,
-	admin bool) ([]entities.Flat, error) {
-	conn, err := s.getConnection()
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	admin bool,
+	filter entities.FlatFilter) (*entities.FlatPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.searchTimeout)
 	defer cancel()
-	return s.flats.FilterFlats(conn, ctx, homeId, admin)
+	return s.flats.FilterFlats(s.db, ctx, homeId, admin, filter)
 }
 
-func (s *Storage) GetLastHomeUpdate(homeId int) (time.Time, error) {
-	conn, err := s.getConnection()
-	if err != nil {
-		return time.Unix(0, 0), err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+func (s *Storage) SetMarketStatus(
+	ctx context.Context,
+	flatId int,
+	homeId int,
+	status entities.MarketStatus,
+	userId string,
+	admin bool) (*entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	return s.homes.GetLastHomeUpdate(conn, ctx, homeId)
+	var flat *entities.Flat
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		flat, err = s.flats.SetMarketStatus(txn, ctx, flatId, homeId, status, userId, admin)
+		return err
+	})
+	return flat, err
 }
 
-func (s *Storage) GetHomeReviewer(homeId int) (string, error) {
-	conn, err := s.getConnection()
-	if err != nil {
-		return "", err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+func (s *Storage) StartModeration(ctx context.Context, homeId int, moderatorId string) ([]entities.Flat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var flats []entities.Flat
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		flats, err = s.flats.StartModeration(txn, ctx, homeId, moderatorId)
+		return err
+	})
+	return flats, err
+}
+
+func (s *Storage) SearchFlats(ctx context.Context, filter entities.FlatFilter) (*entities.FlatPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.searchTimeout)
+	defer cancel()
+	return s.flats.SearchFlats(s.db, ctx, filter)
+}
+
+func (s *Storage) GetFlatHistory(ctx context.Context, homeId int, flatId int) ([]entities.FlatModerationEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var history []entities.FlatModerationEvent
+	err := WithTx(ctx, s.db, readOnly, func(txn pgx.Tx) (err error) {
+		history, err = s.history.GetHistory(txn, ctx, homeId, flatId)
+		return err
+	})
+	return history, err
+}
+
+func (s *Storage) GetLastHomeUpdate(ctx context.Context, homeId int) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.homes.GetLastHomeUpdate(s.db, ctx, homeId)
+}
+
+func (s *Storage) GetHomeReviewer(ctx context.Context, homeId int) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.homes.GetHomeReviewer(s.db, ctx, homeId)
+}
+
+func (s *Storage) CreateSubscription(ctx context.Context, homeId int, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	return s.homes.GetHomeReviewer(conn, ctx, homeId)
+	return s.subs.CreateSubscription(s.db, ctx, homeId, email)
+}
+
+func (s *Storage) GetSubscribers(ctx context.Context, homeId int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.subs.GetSubscribers(s.db, ctx, homeId)
+}
+
+func (s *Storage) DispatchOutbox(
+	ctx context.Context,
+	batchSize int,
+	maxAttempts int,
+	handle func(context.Context, entities.OutboxEvent) error) (int, error) {
+	var delivered int
+	err := WithTx(ctx, s.db, readWrite, func(txn pgx.Tx) (err error) {
+		delivered, err = s.outbox.Dispatch(txn, ctx, batchSize, maxAttempts, handle)
+		return err
+	})
+	return delivered, err
 }
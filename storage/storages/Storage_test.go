@@ -0,0 +1,120 @@
+//go:build integration
+
+package storages
+
+import (
+	"bootcamp_task/config"
+	"bootcamp_task/storage/entities"
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"testing"
+	"time"
+)
+
+// BenchmarkHouseFlats replays the reads GET /house/{id} makes for a client
+// on a cache miss, the last update of the house and a page of its flats,
+// from many goroutines. "acquire-tx" is the former access path: every read
+// acquires its own connection from the pool and runs in a read-write
+// transaction on it. "pool-no-tx" is the current one, each read is a single
+// query on the pool without a transaction. Both apply the same per-read
+// timeouts. Besides ns/op the benchmark reports opened connections and
+// waits for a free connection per 1000 requests. Run it against the
+// database from handlers/config.yaml:
+//
+//	go test -tags integration -run '^$' -bench HouseFlats ./storage/storages
+func BenchmarkHouseFlats(b *testing.B) {
+	s := NewStorage(config.ParseConfigFile("../../handlers/config.yaml"))
+	ctx := context.Background()
+	home, err := s.CreateHome(ctx, "Benchmark "+time.Now().Format(time.RFC3339Nano), 2000, "Stroy", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	flats := make([]entities.Flat, 50)
+	for i := range flats {
+		number := i + 1
+		flats[i] = entities.Flat{Number: number, Price: number * 1000, Rooms: 1 + number%4}
+	}
+	if _, err := s.ImportFlats(ctx, home.Id, flats, ""); err != nil {
+		b.Fatal(err)
+	}
+	filter := entities.FlatFilter{Sort: "number", Limit: 20}
+
+	b.Run("acquire-tx", func(b *testing.B) {
+		benchmarkReads(b, s, func() error {
+			return readHouseFlatsOnConn(s, ctx, home.Id, filter)
+		})
+	})
+	b.Run("pool-no-tx", func(b *testing.B) {
+		benchmarkReads(b, s, func() error {
+			if _, err := s.GetLastHomeUpdate(ctx, home.Id); err != nil {
+				return err
+			}
+			_, err := s.FilterFlats(ctx, home.Id, false, filter)
+			return err
+		})
+	})
+}
+
+// readHouseFlatsOnConn reads the house the way Storage did before reads
+// went to the pool: every facade method took a connection with
+// getConnection and ran its query in conn.BeginTx(ctx, nil), a read-write
+// transaction with the default isolation level.
+func readHouseFlatsOnConn(s *Storage, ctx context.Context, homeId int, filter entities.FlatFilter) error {
+	if err := acquireTx(s, ctx, s.timeout, func(txn pgx.Tx, ctx context.Context) error {
+		_, err := s.homes.GetLastHomeUpdate(txn, ctx, homeId)
+		return err
+	}); err != nil {
+		return err
+	}
+	return acquireTx(s, ctx, s.searchTimeout, func(txn pgx.Tx, ctx context.Context) error {
+		_, err := s.flats.FilterFlats(txn, ctx, homeId, false, filter)
+		return err
+	})
+}
+
+// acquireTx runs read in a read-write transaction on a connection acquired
+// for it alone and releases the connection afterwards.
+func acquireTx(
+	s *Storage,
+	ctx context.Context,
+	timeout time.Duration,
+	read func(txn pgx.Tx, ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	txn, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite})
+	if err != nil {
+		return err
+	}
+	if err := read(txn, ctx); err != nil {
+		txn.Rollback(ctx)
+		return err
+	}
+	return txn.Commit(ctx)
+}
+
+func benchmarkReads(b *testing.B, s *Storage, read func() error) {
+	before := s.db.Stat()
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := read(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	after := s.db.Stat()
+	perOp := func(count func(stat *pgxpool.Stat) int64) float64 {
+		return float64(count(after)-count(before)) * 1000 / float64(b.N)
+	}
+	b.ReportMetric(perOp((*pgxpool.Stat).NewConnsCount), "conns-opened/1k-op")
+	b.ReportMetric(perOp((*pgxpool.Stat).EmptyAcquireCount), "waits/1k-op")
+}
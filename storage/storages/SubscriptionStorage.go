@@ -0,0 +1,52 @@
+package storages
+
+import (
+	"context"
+	"time"
+)
+
+type SubscriptionStorage struct {
+}
+
+// CreateSubscription subscribes email to the house unless it is missing or
+// deleted, in which case ErrHouseNotFound is returned. The house row is
+// share-locked, so it cannot be deleted before the subscription is written.
+func (s SubscriptionStorage) CreateSubscription(
+	q queryer,
+	ctx context.Context,
+	homeId int,
+	email string) error {
+	query := `WITH home AS (SELECT id FROM homes WHERE id=$1 AND deleted_at IS NULL FOR SHARE),
+		inserted AS (INSERT INTO subscriptions (home_id, email, created_at) SELECT id, $2, $3 FROM home
+			ON CONFLICT (home_id, email) DO NOTHING)
+		SELECT EXISTS (SELECT 1 FROM home)`
+	var exists bool
+	err := q.QueryRow(ctx, query, homeId, email, time.Now().UTC()).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrHouseNotFound
+	}
+	return nil
+}
+
+func (s SubscriptionStorage) GetSubscribers(
+	q queryer,
+	ctx context.Context,
+	homeId int) ([]string, error) {
+	rows, err := q.Query(ctx, "SELECT email FROM subscriptions WHERE home_id=$1", homeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]string, 0)
+	for rows.Next() {
+		var email string
+		if errscan := rows.Scan(&email); errscan != nil {
+			return nil, errscan
+		}
+		result = append(result, email)
+	}
+	return result, rows.Err()
+}
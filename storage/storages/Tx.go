@@ -0,0 +1,84 @@
+package storages
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxTxAttempts bounds retries of transactions aborted by a serialization
+// failure or a deadlock, txRetryDelay is the pause before the first retry
+// and doubles with every next one.
+const (
+	maxTxAttempts = 3
+	txRetryDelay  = 10 * time.Millisecond
+)
+
+// readWrite is the default mode of transactions changing data.
+var readWrite = pgx.TxOptions{}
+
+// readOnly runs reads of several statements on one consistent snapshot.
+// Read-only transactions never fail with serialization errors.
+var readOnly = pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}
+
+// queryer is implemented by *pgxpool.Pool and pgx.Tx, so single statements
+// run right on the pool and several ones inside a transaction.
+type queryer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, batch *pgx.Batch) pgx.BatchResults
+}
+
+// sendBatch runs the queued statements in one round trip. It returns the
+// first error of a statement or of a callback registered on it.
+func sendBatch(ctx context.Context, q queryer, batch *pgx.Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	return q.SendBatch(ctx, batch).Close()
+}
+
+// WithTx runs fn in a transaction of db opened with opts. The transaction is
+// committed if fn returns nil and rolled back if fn fails or panics; the
+// panic is raised again after the rollback. Transactions aborted by a
+// serialization failure or a deadlock are retried from scratch, so fn must
+// change state only through txn.
+func WithTx(ctx context.Context, db *pgxpool.Pool, opts pgx.TxOptions, fn func(txn pgx.Tx) error) error {
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, db, opts, fn)
+		if !isRetryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func runTx(ctx context.Context, db *pgxpool.Pool, opts pgx.TxOptions, fn func(txn pgx.Tx) error) (err error) {
+	txn, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			txn.Rollback(context.WithoutCancel(ctx))
+			panic(p)
+		}
+		if err != nil {
+			txn.Rollback(context.WithoutCancel(ctx))
+		}
+	}()
+	if err = fn(txn); err != nil {
+		return err
+	}
+	return txn.Commit(ctx)
+}
@@ -3,32 +3,25 @@ package storages
 import (
 	"bootcamp_task/storage/entities"
 	"context"
-	"database/sql"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type UserStorage struct {
 }
 
 func (u UserStorage) CreateUser(
-	conn *sql.Conn,
+	txn pgx.Tx,
 	ctx context.Context,
 	email string,
 	password string,
 	isAdmin bool) (string, error) {
-	defer conn.Close()
-
-	txn, err := conn.BeginTx(ctx, nil)
-	if err != nil {
-		return "", err
-	}
-
 	query := "INSERT INTO users (id, email, password, is_admin) VALUES ($1, $2, $3, $4)"
 	supportiveQuery := "SELECT COUNT(*) FROM users WHERE id=$1"
 	id := uuid.New()
 	var value int
 	for {
-		err := txn.QueryRow(supportiveQuery, id.String()).Scan(&value)
+		err := txn.QueryRow(ctx, supportiveQuery, id.String()).Scan(&value)
 		if err != nil {
 			return "", err
 		}
@@ -37,44 +30,40 @@ func (u UserStorage) CreateUser(
 		}
 		id = uuid.New()
 	}
-	_, err = txn.Exec(query, id.String(), email, password, isAdmin)
-	if err != nil {
-		return "", err
+	_, err := txn.Exec(ctx, query, id.String(), email, password, isAdmin)
+	if isUniqueViolation(err) {
+		return "", ErrUserExists
 	}
-
-	err = txn.Commit()
 	if err != nil {
 		return "", err
 	}
-	return id.String(), err
+	return id.String(), nil
 }
 
 func (u UserStorage) GetUser(
-	conn *sql.Conn,
+	q queryer,
 	ctx context.Context,
 	email string) (*entities.User, error) {
-	defer conn.Close()
-
-	txn, err := conn.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, err
-	}
-
 	query := "SELECT id, email, password, is_admin FROM users WHERE email=$1"
 	user := entities.User{}
-	err = txn.QueryRow(query, email).Scan(
+	err := q.QueryRow(ctx, query, email).Scan(
 		&user.Id,
 		&user.Email,
 		&user.Password,
 		&user.IsAdmin,
 	)
 	if err != nil {
-		return nil, err
-	}
-
-	err = txn.Commit()
-	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrUserNotFound)
 	}
 	return &user, nil
 }
+
+func (u UserStorage) UpdateUserPassword(
+	q queryer,
+	ctx context.Context,
+	id string,
+	password string) error {
+	query := "UPDATE users SET password=$1 WHERE id=$2"
+	_, err := q.Exec(ctx, query, password, id)
+	return err
+}